@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const defaultMaxBodyBytes = 1 << 20
+
+// statusRange is a range of HTTP status codes, written as 200, "2xx" or
+// "200-299" in the config
+type statusRange struct {
+	min, max int
+	text     string
+}
+
+// statusRanges is the expect_status of an endpoint, a single range or a list
+type statusRanges []statusRange
+
+func parseStatusRange(text string) (statusRange, error) {
+	r := statusRange{text: text}
+	var err error
+	switch {
+	case len(text) == 3 && strings.HasSuffix(strings.ToLower(text), "xx"):
+		var class int
+		class, err = strconv.Atoi(text[:1])
+		r.min, r.max = class*100, class*100+99
+	case strings.Contains(text, "-"):
+		parts := strings.SplitN(text, "-", 2)
+		if r.min, err = strconv.Atoi(strings.TrimSpace(parts[0])); err == nil {
+			r.max, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		}
+	default:
+		r.min, err = strconv.Atoi(text)
+		r.max = r.min
+	}
+	if err != nil || r.min < 100 || r.max > 599 || r.min > r.max {
+		return r, fmt.Errorf("invalid status %q, use a code like 200, a class like 2xx or a range like 200-299", text)
+	}
+	return r, nil
+}
+
+func (s *statusRanges) UnmarshalTOML(data interface{}) error {
+	values, ok := data.([]interface{})
+	if !ok {
+		values = []interface{}{data}
+	}
+	for _, value := range values {
+		var text string
+		switch v := value.(type) {
+		case int64:
+			text = strconv.FormatInt(v, 10)
+		case string:
+			text = v
+		default:
+			return fmt.Errorf("invalid status %v, use a number or a string", value)
+		}
+		r, err := parseStatusRange(text)
+		if err != nil {
+			return err
+		}
+		*s = append(*s, r)
+	}
+	return nil
+}
+
+func (s statusRanges) match(statusCode int) bool {
+	for _, r := range s {
+		if statusCode >= r.min && statusCode <= r.max {
+			return true
+		}
+	}
+	return false
+}
+
+func (s statusRanges) String() string {
+	texts := make([]string, len(s))
+	for i, r := range s {
+		texts[i] = r.text
+	}
+	return strings.Join(texts, ", ")
+}
+
+// bodyAssertions are the checks of the response body of an endpoint
+type bodyAssertions struct {
+	contains     string
+	regexp       *regexp.Regexp
+	maxBodyBytes int64
+}
+
+func (b *bodyAssertions) empty() bool {
+	return b == nil || (b.contains == "" && b.regexp == nil && b.maxBodyBytes == 0)
+}
+
+// loadBodyAssertions compiles the body assertions of an endpoint table
+func loadBodyAssertions(epConfig endpointConfig) (*bodyAssertions, error) {
+	assertions := &bodyAssertions{contains: epConfig.BodyContains, maxBodyBytes: epConfig.MaxBodyBytes}
+	if epConfig.MaxBodyBytes < 0 {
+		return nil, fmt.Errorf("max_body_bytes must not be negative, got %d", epConfig.MaxBodyBytes)
+	}
+	if epConfig.BodyRegex != "" {
+		re, err := regexp.Compile(epConfig.BodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body_regex: %s", err)
+		}
+		assertions.regexp = re
+	}
+	if assertions.empty() {
+		return nil, nil
+	}
+	return assertions, nil
+}
+
+// checkBody evaluates the body assertions, reading at most max_body_bytes
+// (or 1 MiB) of the body. An empty result means all assertions hold.
+func checkBody(assertions *bodyAssertions, body io.Reader) (string, error) {
+	limit := assertions.maxBodyBytes
+	if limit == 0 {
+		limit = defaultMaxBodyBytes
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > limit {
+		if assertions.maxBodyBytes > 0 {
+			return fmt.Sprintf("body larger than %d bytes", limit), nil
+		}
+		data = data[:limit]
+	}
+	if assertions.contains != "" && !strings.Contains(string(data), assertions.contains) {
+		return fmt.Sprintf("body does not contain %q", assertions.contains), nil
+	}
+	if assertions.regexp != nil && !assertions.regexp.Match(data) {
+		return fmt.Sprintf("body does not match %q", assertions.regexp.String()), nil
+	}
+	return "", nil
+}
+
+// checkAssertions checks the expected status and the body of the response.
+// An expected status replaces the rule that an error status means unknown.
+func checkAssertions(ep *endpoint, response *http.Response) {
+	if len(ep.expectStatus) > 0 {
+		ep.Unknown = false
+		if !ep.expectStatus.match(response.StatusCode) {
+			ep.AssertionFailure = fmt.Sprintf("expected status %s", ep.expectStatus)
+			ep.Success = false
+			return
+		}
+	}
+	if ep.bodyAssertions.empty() {
+		return
+	}
+	failure, err := checkBody(ep.bodyAssertions, response.Body)
+	if err != nil {
+		failure = fmt.Sprintf("could not read body: %s", err)
+	}
+	if failure != "" {
+		ep.AssertionFailure = failure
+		ep.Success = false
+	}
+}
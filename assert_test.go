@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestParseStatusRange(t *testing.T) {
+	testCases := []struct {
+		text     string
+		min, max int
+		valid    bool
+	}{
+		{"200", 200, 200, true},
+		{"2xx", 200, 299, true},
+		{"3XX", 300, 399, true},
+		{"200-204", 200, 204, true},
+		{"204-200", 0, 0, false},
+		{"9xx", 0, 0, false},
+		{"ok", 0, 0, false},
+		{"42", 0, 0, false},
+	}
+	for _, tc := range testCases {
+		r, err := parseStatusRange(tc.text)
+		if (err == nil) != tc.valid {
+			t.Errorf("Incorrect validity for %q: %v", tc.text, err)
+			continue
+		}
+		if tc.valid && (r.min != tc.min || r.max != tc.max) {
+			t.Errorf("Incorrect range %d-%d for %q", r.min, r.max, tc.text)
+		}
+	}
+}
+
+func TestExpectStatusTOML(t *testing.T) {
+	var config configuration
+	_, err := toml.Decode(`
+[[site]]
+base = "https://example.com"
+expect_status = "2xx"
+
+[[site.endpoint]]
+path = "a"
+expect_status = [200, 204]
+
+[[site.endpoint]]
+path = "b"
+expect_status = ["2xx", "301-302"]
+
+[[site.endpoint]]
+path = "c"
+expect_status = 404
+`, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wanted := []string{"200, 204", "2xx, 301-302", "404"}
+	for i, epConfig := range config.Sites[0].Endpoints {
+		if got := epConfig.ExpectStatus.String(); got != wanted[i] {
+			t.Errorf("Incorrect expect_status %q, wanted %q", got, wanted[i])
+		}
+	}
+	if got := config.Sites[0].ExpectStatus.String(); got != "2xx" {
+		t.Errorf("Incorrect site expect_status %q", got)
+	}
+	if _, err := toml.Decode("expect_status = \"8xx\"", &endpointConfig{}); err == nil {
+		t.Error("Expected error for invalid expect_status")
+	}
+}
+
+func TestCheckURLAssertions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/gone":
+			w.WriteHeader(http.StatusNotFound)
+		case "/big":
+			fmt.Fprint(w, strings.Repeat("x", 2048))
+		default:
+			fmt.Fprint(w, "<title>Welcome</title> build 1234")
+		}
+	}))
+	defer ts.Close()
+	var config configuration
+	_, err := toml.Decode(fmt.Sprintf(`
+[[site]]
+base = "%s"
+no_auth = ["", "broken"]
+expect_status = "2xx"
+
+[[site.endpoint]]
+path = "gone"
+expect_scheme = "none"
+expect_status = 404
+
+[[site.endpoint]]
+path = "welcome"
+expect_scheme = "none"
+body_contains = "Welcome"
+body_regex = 'build \d+'
+
+[[site.endpoint]]
+path = "maintenance"
+expect_scheme = "none"
+body_contains = "Maintenance"
+
+[[site.endpoint]]
+path = "big"
+expect_scheme = "none"
+max_body_bytes = 1024
+`, ts.URL), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := populateURLConfig(config.Sites); err != nil {
+		t.Fatal(err)
+	}
+	checkSites(context.Background(), config.Sites, 2)
+	wanted := map[string]string{
+		"/":            "",
+		"/broken":      "expected status 2xx",
+		"/gone":        "",
+		"/welcome":     "",
+		"/maintenance": `body does not contain "Maintenance"`,
+		"/big":         "body larger than 1024 bytes",
+	}
+	for _, ep := range config.Sites[0].endpoints {
+		path := strings.TrimPrefix(ep.URL, ts.URL)
+		if ep.AssertionFailure != wanted[path] {
+			t.Errorf("Incorrect assertion failure %q for %s, wanted %q", ep.AssertionFailure, path, wanted[path])
+		}
+		if ep.Success != (wanted[path] == "") || ep.Unknown {
+			t.Errorf("Incorrect result for %s: success %t, unknown %t", path, ep.Success, ep.Unknown)
+		}
+	}
+
+	invalid := []site{site{Base: ts.URL, Endpoints: []endpointConfig{{Path: "x", BodyRegex: "("}}}}
+	if err := populateURLConfig(invalid); err == nil {
+		t.Error("Expected error for invalid body_regex")
+	}
+}
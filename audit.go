@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	auditMethods = "methods"
+	auditPaths   = "paths"
+	auditHeaders = "headers"
+)
+
+var (
+	validAudits = []string{auditMethods, auditPaths, auditHeaders}
+	// auditVerbs are tried on protected endpoints by the methods audit, except
+	// the endpoint's own method. The last one is made up to catch rules that
+	// only list the known verbs.
+	auditVerbs = []string{"GET", "HEAD", "OPTIONS", "POST", "PUT", "DELETE", "PATCH", "BACHECK"}
+	// defaultAuditHeaders are the header sets tried by the headers audit,
+	// unless the site configures its own. Proxies that trust them might
+	// skip the authentication for internal clients or public paths.
+	defaultAuditHeaders = []map[string]string{
+		{"X-Forwarded-For": "127.0.0.1"},
+		{"X-Forwarded-For": "10.0.0.1"},
+		{"X-Forwarded-For": "172.16.0.1"},
+		{"X-Forwarded-For": "192.168.0.1"},
+		{"X-Real-IP": "127.0.0.1"},
+		{"X-Real-IP": "10.0.0.1"},
+		{"X-Real-IP": "172.16.0.1"},
+		{"X-Real-IP": "192.168.0.1"},
+		{"X-Forwarded-For": "127.0.0.1", "X-Real-IP": "127.0.0.1"},
+		{"X-Original-URL": "/"},
+		{"X-Rewrite-URL": "/"},
+	}
+)
+
+// validateAudits checks that all the given audits are known
+func validateAudits(audits []string) error {
+	for _, audit := range audits {
+		if !containsString(validAudits, audit) {
+			return fmt.Errorf("unknown audit %q, available audits are: %s", audit, strings.Join(validAudits, ", "))
+		}
+	}
+	return nil
+}
+
+// enableAudits adds the given audits to all sites
+func enableAudits(sites []site, audits []string) {
+	for i := range sites {
+		for _, audit := range audits {
+			if !containsString(sites[i].Audits, audit) {
+				sites[i].Audits = append(sites[i].Audits, audit)
+			}
+		}
+	}
+}
+
+// runAudits runs the enabled audits against a protected endpoint
+func runAudits(ctx context.Context, ep *endpoint) {
+	if containsString(ep.audits, auditMethods) {
+		auditEndpointMethods(ctx, ep)
+	}
+	if containsString(ep.audits, auditPaths) {
+		auditEndpointPaths(ctx, ep)
+	}
+	if containsString(ep.audits, auditHeaders) {
+		auditEndpointHeaders(ctx, ep)
+	}
+}
+
+// auditEndpointMethods repeats the request with other methods, every method
+// that isn't answered with a 401 is a finding
+func auditEndpointMethods(ctx context.Context, ep *endpoint) {
+	for _, method := range auditVerbs {
+		if method == ep.Method {
+			continue
+		}
+		variant := *ep
+		variant.Method = method
+		req, err := newRequest(ctx, &variant)
+		if err != nil {
+			continue
+		}
+		response, err := doRequest(ctx, ep, req)
+		if err != nil {
+			// Not getting an answer is no bypass
+			continue
+		}
+		response.Body.Close()
+		if bypassed(response.StatusCode) {
+			ep.Findings = append(ep.Findings, finding{
+				Check:      auditMethods,
+				Severity:   severityWarning,
+				Method:     method,
+				URL:        ep.URL,
+				StatusCode: response.StatusCode,
+				Status:     response.Status,
+			})
+		}
+	}
+}
+
+// bypassed tells whether a request to a protected endpoint got through. A
+// refusal like 403, 405 or 501 is no bypass.
+func bypassed(statusCode int) bool {
+	return statusCode >= 200 && statusCode < 400
+}
+
+// pathVariants derives variants of a path that a server might map to the same
+// resource, while a badly anchored auth rule doesn't match them
+func pathVariants(path string) []string {
+	trimmed := strings.TrimSuffix(path, "/")
+	slash := strings.LastIndex(trimmed, "/")
+	dir, segment := trimmed[:slash+1], trimmed[slash+1:]
+
+	var variants []string
+	if strings.HasSuffix(path, "/") {
+		variants = append(variants, trimmed)
+	} else {
+		variants = append(variants, path+"/")
+	}
+	if upper := strings.ToUpper(segment); upper != segment {
+		variants = append(variants, dir+upper)
+	} else {
+		variants = append(variants, dir+strings.ToLower(segment))
+	}
+	if segment != "" {
+		variants = append(variants, fmt.Sprintf("%s%%%02X%s", dir, segment[0], segment[1:]))
+	}
+	variants = append(variants,
+		"/"+path,
+		dir+"./"+segment,
+		dir+"bacheck/../"+segment,
+		trimmed+";x",
+	)
+
+	var unique []string
+	for _, variant := range variants {
+		if strings.HasPrefix(variant, "/") && variant != path && !containsString(unique, variant) {
+			unique = append(unique, variant)
+		}
+	}
+	return unique
+}
+
+// auditEndpointPaths requests variants of the endpoint's path exactly as
+// derived, without the cleaning Go would do. Every variant that isn't
+// protected, going by the same rules as the endpoint itself, is a finding.
+func auditEndpointPaths(ctx context.Context, ep *endpoint) {
+	u, err := url.Parse(ep.URL)
+	if err != nil {
+		return
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	for _, variant := range pathVariants(path) {
+		req, err := newRequest(ctx, ep)
+		if err != nil {
+			continue
+		}
+		req.URL.Opaque = variant
+		if strings.HasPrefix(variant, "//") {
+			// Would be taken for the host, so send it in absolute form
+			req.URL.Opaque = "//" + req.URL.Host + variant
+		}
+		response, err := doRequest(ctx, ep, req)
+		if err != nil {
+			continue
+		}
+		success, _, unknown := checkSuccess(response, true, acceptedSchemes(*ep))
+		response.Body.Close()
+		if !success && !unknown {
+			variantURL := fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, variant)
+			if u.RawQuery != "" {
+				variantURL += "?" + u.RawQuery
+			}
+			ep.Findings = append(ep.Findings, finding{
+				Check:      auditPaths,
+				Severity:   severityWarning,
+				Method:     req.Method,
+				URL:        variantURL,
+				StatusCode: response.StatusCode,
+				Status:     response.Status,
+			})
+		}
+	}
+}
+
+// auditEndpointHeaders repeats the request with every set of spoofed headers,
+// every set that gets a 2xx or 3xx answer instead of the 401 is a finding
+func auditEndpointHeaders(ctx context.Context, ep *endpoint) {
+	headerSets := ep.auditHeaders
+	if len(headerSets) == 0 {
+		headerSets = defaultAuditHeaders
+	}
+	for _, headers := range headerSets {
+		variant := *ep
+		variant.headers = mergeHeaders(ep.headers, headers)
+		req, err := newRequest(ctx, &variant)
+		if err != nil {
+			continue
+		}
+		response, err := doRequest(ctx, ep, req)
+		if err != nil {
+			continue
+		}
+		response.Body.Close()
+		if bypassed(response.StatusCode) {
+			ep.Findings = append(ep.Findings, finding{
+				Check:      auditHeaders,
+				Severity:   severityWarning,
+				Method:     req.Method,
+				URL:        ep.URL,
+				Headers:    headers,
+				StatusCode: response.StatusCode,
+				Status:     response.Status,
+			})
+		}
+	}
+}
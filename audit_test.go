@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestAuditMethods(t *testing.T) {
+	// Only GET and POST are protected, like a <Limit GET POST> block.
+	// Refusing DELETE and the made up verb is no bypass.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case r.Method == "BACHECK":
+			w.WriteHeader(http.StatusNotImplemented)
+		case r.URL.Path == "/admin" && (r.Method == "GET" || r.Method == "POST"):
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+	sites := []site{
+		site{Base: ts.URL, BasicAuth: []string{"admin"}, NoBasicAuth: []string{"public"}, PlainHTTPSeverity: severityWarning},
+	}
+	enableAudits(sites, []string{auditMethods})
+	if err := populateURLConfig(sites); err != nil {
+		t.Fatal(err)
+	}
+	checkSites(context.Background(), sites, 2)
+
+	findings := map[string]int{}
+	for _, ep := range sites[0].endpoints {
+		if !ep.BaShouldBe && len(ep.Findings) > 0 {
+			t.Errorf("Unprotected endpoint %s was audited", ep.URL)
+		}
+		if !ep.Success {
+			t.Errorf("Incorrect success for %s", ep.URL)
+		}
+		for _, f := range ep.Findings {
+			findings[f.Method] = f.StatusCode
+		}
+	}
+	for _, method := range []string{"HEAD", "OPTIONS", "PUT", "PATCH"} {
+		if findings[method] != 200 {
+			t.Errorf("Missing finding for %s", method)
+		}
+	}
+	for _, method := range []string{"POST", "DELETE", "BACHECK"} {
+		if _, ok := findings[method]; ok {
+			t.Errorf("Unexpected finding for %s", method)
+		}
+	}
+	if status := checkStatus(sites, countThreshold(1), countThreshold(2)); status != 1 {
+		t.Errorf("Incorrect status %d with audit findings, wanted 1", status)
+	}
+}
+
+func TestAuditMethodsGet(t *testing.T) {
+	// Only POST is protected, like a <Limit POST> block
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			w.Header().Set("WWW-Authenticate", `Basic realm="api"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case "GET":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer ts.Close()
+	var config configuration
+	_, err := toml.Decode(fmt.Sprintf(`
+[[site]]
+base = "%s"
+plain_http_severity = "warning"
+audit = ["methods"]
+
+[[site.endpoint]]
+path = "api"
+method = "POST"
+`, ts.URL), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := populateURLConfig(config.Sites); err != nil {
+		t.Fatal(err)
+	}
+	checkSites(context.Background(), config.Sites, 1)
+	var methods []string
+	for _, f := range config.Sites[0].endpoints[0].Findings {
+		if f.Check == auditMethods {
+			methods = append(methods, f.Method)
+		}
+	}
+	if len(methods) != 1 || methods[0] != "GET" {
+		t.Errorf("Expected a single finding for GET, got %v", methods)
+	}
+}
+
+func TestValidateAudits(t *testing.T) {
+	if err := validateAudits([]string{auditMethods}); err != nil {
+		t.Error(err)
+	}
+	if err := validateAudits([]string{"everything"}); err == nil {
+		t.Error("Expected error for unknown audit")
+	}
+	sites := []site{site{Base: "https://example.com", Audits: []string{"everything"}}}
+	if err := populateURLConfig(sites); err == nil {
+		t.Error("Expected error for unknown audit in the config")
+	}
+}
+
+func TestPathVariants(t *testing.T) {
+	wanted := []string{"/admin/", "/ADMIN", "/%61dmin", "//admin", "/./admin", "/bacheck/../admin", "/admin;x"}
+	got := pathVariants("/admin")
+	if strings.Join(got, " ") != strings.Join(wanted, " ") {
+		t.Errorf("Incorrect variants %q, wanted %q", got, wanted)
+	}
+	got = pathVariants("/api/ADMIN/")
+	wanted = []string{"/api/ADMIN", "/api/admin", "/api/%41DMIN", "//api/ADMIN/", "/api/./ADMIN", "/api/bacheck/../ADMIN", "/api/ADMIN;x"}
+	if strings.Join(got, " ") != strings.Join(wanted, " ") {
+		t.Errorf("Incorrect variants %q, wanted %q", got, wanted)
+	}
+}
+
+func TestAuditPaths(t *testing.T) {
+	// The auth rule only matches the exact path, everything else is served
+	var received []string
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = append(received, r.RequestURI)
+		mu.Unlock()
+		switch {
+		case r.URL.EscapedPath() == "/admin":
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/ADMIN":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	sites := []site{site{Base: ts.URL, BasicAuth: []string{"admin"}, Audits: []string{auditPaths}}}
+	if err := populateURLConfig(sites); err != nil {
+		t.Fatal(err)
+	}
+	checkSites(context.Background(), sites, 1)
+
+	ep := sites[0].endpoints[0]
+	if !ep.Success {
+		t.Errorf("Incorrect success for %s", ep.URL)
+	}
+	findings := map[string]bool{}
+	for _, f := range ep.Findings {
+		findings[strings.TrimPrefix(f.URL, ts.URL)] = true
+	}
+	for _, variant := range []string{"/admin/", "/%61dmin", "//admin", "/./admin", "/bacheck/../admin", "/admin;x"} {
+		if !findings[variant] {
+			t.Errorf("Missing finding for %s", variant)
+		}
+	}
+	if findings["/ADMIN"] {
+		t.Error("Unexpected finding for a variant answered with 404")
+	}
+	// The variants must reach the server as they are
+	for _, uri := range []string{"/%61dmin", "/./admin", "/bacheck/../admin"} {
+		found := false
+		for _, r := range received {
+			found = found || r == uri
+		}
+		if !found {
+			t.Errorf("Variant %s was not sent raw, received %q", uri, received)
+		}
+	}
+}
+
+func TestAuditHeaders(t *testing.T) {
+	// A proxy that lets internal clients and public paths through
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Forwarded-For") == "127.0.0.1" || r.Header.Get("X-Original-URL") == "/" ||
+			r.Header.Get("X-Internal") == "yes" {
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+	var config configuration
+	_, err := toml.Decode(fmt.Sprintf(`
+[[site]]
+base = "%s"
+auth = ["admin"]
+audit = ["headers"]
+plain_http_severity = "warning"
+
+[[site]]
+base = "%s"
+auth = ["admin"]
+audit = ["headers"]
+plain_http_severity = "warning"
+
+[[site.audit_headers]]
+X-Internal = "yes"
+X-Real-IP = "10.0.0.1"
+
+[[site.audit_headers]]
+X-Internal = "no"
+`, ts.URL, ts.URL), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := populateURLConfig(config.Sites); err != nil {
+		t.Fatal(err)
+	}
+	checkSites(context.Background(), config.Sites, 2)
+
+	var headerFindings []string
+	for _, f := range config.Sites[0].endpoints[0].Findings {
+		if f.Check == auditHeaders {
+			headerFindings = append(headerFindings, headersMessage(f.Headers))
+		}
+	}
+	wanted := []string{
+		"X-Forwarded-For: 127.0.0.1",
+		"X-Forwarded-For: 127.0.0.1\nX-Real-IP: 127.0.0.1",
+		"X-Original-URL: /",
+	}
+	if strings.Join(headerFindings, "|") != strings.Join(wanted, "|") {
+		t.Errorf("Incorrect findings with the default headers %q, wanted %q", headerFindings, wanted)
+	}
+
+	headerFindings = nil
+	for _, f := range config.Sites[1].endpoints[0].Findings {
+		if f.Check == auditHeaders {
+			headerFindings = append(headerFindings, headersMessage(f.Headers))
+		}
+	}
+	if len(headerFindings) != 1 || headerFindings[0] != "X-Internal: yes\nX-Real-IP: 10.0.0.1" {
+		t.Errorf("Incorrect findings with the configured headers %q", headerFindings)
+	}
+}
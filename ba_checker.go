@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -18,7 +26,17 @@ const (
 	toolVersion = "v0.8"
 )
 
+// Error categories for endpoints that could not be checked at all
+const (
+	errorDNS      = "dns"
+	errorConnect  = "connect"
+	errorTLS      = "tls"
+	errorTimeout  = "timeout"
+	errorProtocol = "protocol"
+)
+
 var (
+	outputFormats       = []string{"table", "nagios", "json", "junit", "realms"}
 	lookUpStatusCodeMap = map[int]string{
 		0: "OK",
 		1: "WARNING",
@@ -27,24 +45,137 @@ var (
 	}
 )
 
+// schemeNone is the expected scheme of endpoints that must not require auth
+const schemeNone = "none"
+
+var (
+	validExpectSchemes = []string{schemeBasic, schemeDigest, schemeBearer, schemeNegotiate, schemeNTLM, schemeMTLS, schemeNone}
+)
+
 type configuration struct {
 	Sites []site `toml:"site"`
 }
 type site struct {
-	Base        string   `toml:"base"`
-	BasicAuth   []string `toml:"auth"`
-	NoBasicAuth []string `toml:"no_auth"`
-	endpoints   []endpoint
+	Base         string           `toml:"base"`
+	BasicAuth    []string         `toml:"auth"`
+	NoBasicAuth  []string         `toml:"no_auth"`
+	AuthSchemes  []string         `toml:"auth_schemes"`
+	Credentials  *credentials     `toml:"credentials"`
+	CheckInvalid bool             `toml:"check_invalid_credentials"`
+	Endpoints    []endpointConfig `toml:"endpoint"`
+
+	AllowInsecureCredentials bool              `toml:"allow_insecure_credentials"`
+	Headers                  map[string]string `toml:"headers"`
+
+	Timeout               duration `toml:"timeout"`
+	ConnectTimeout        duration `toml:"connect_timeout"`
+	TLSHandshakeTimeout   duration `toml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout duration `toml:"response_header_timeout"`
+
+	TLS *tlsOptions `toml:"tls"`
+
+	MaxConcurrent     int          `toml:"max_concurrent"`
+	RequestsPerSecond float64      `toml:"requests_per_second"`
+	Retry             *retryPolicy `toml:"retry"`
+
+	Realm        string              `toml:"realm"`
+	Audits       []string            `toml:"audit"`
+	AuditHeaders []map[string]string `toml:"audit_headers"`
+
+	Redirect     string `toml:"redirect"`
+	MaxRedirects int    `toml:"max_redirects"`
+	RedirectTo   string `toml:"redirect_to"`
+
+	ExpectStatus statusRanges `toml:"expect_status"`
+
+	PlainHTTPSeverity  string `toml:"plain_http_severity"`
+	CheckHTTPSRedirect bool   `toml:"check_https_redirect"`
+
+	Warning  *threshold `toml:"warning"`
+	Critical *threshold `toml:"critical"`
+
+	endpoints []endpoint
+	tlsConfig *tls.Config
+	status    int
+}
+
+// endpointConfig is a [[site.endpoint]] table, for endpoints that need more
+// than the auth/no_auth lists can express
+type endpointConfig struct {
+	Path         string       `toml:"path"`
+	ExpectScheme string       `toml:"expect_scheme"`
+	Realm        string       `toml:"realm"`
+	Credentials  *credentials `toml:"credentials"`
+	CheckInvalid bool         `toml:"check_invalid_credentials"`
+
+	Method  string            `toml:"method"`
+	Headers map[string]string `toml:"headers"`
+	Body    string            `toml:"body"`
+
+	RedirectTo string `toml:"redirect_to"`
+
+	ExpectStatus statusRanges `toml:"expect_status"`
+	BodyContains string       `toml:"body_contains"`
+	BodyRegex    string       `toml:"body_regex"`
+	MaxBodyBytes int64        `toml:"max_body_bytes"`
 }
 
 type endpoint struct {
 	BaShouldBe     bool
+	Method         string
 	URL            string
+	Path           string
+	ExpectScheme   string
+	ExpectRealm    string
+	ExpectRedirect string
+	AuthSchemes    []string
 	BaEnabled      bool
+	AuthScheme     string
+	Realm          string
+	Challenges     []challenge
+	Redirects      []string
+	Redirected     bool
+	TLSVersion     string
+	CertExpiry     time.Time
 	Success        bool
 	Unknown        bool
 	HTTPStatus     string
 	HTTPStatusCode int
+	Duration       time.Duration
+	Attempts       int
+	Error          bool
+	ErrorCategory  string
+	ErrorMessage   string
+
+	client  *http.Client
+	limiter *siteLimiter
+	retry   *retryPolicy
+	headers map[string]string
+	body    string
+
+	credentials              *credentials
+	allowInsecureCredentials bool
+	CredentialsChecked       bool
+	CredentialsSkipped       bool
+	CredentialsOK            bool
+	CredentialsStatus        string
+
+	checkInvalid                bool
+	InvalidCredentialsChecked   bool
+	InvalidCredentialsError     bool
+	InvalidCredentialsStatus    string
+	AcceptsArbitraryCredentials bool
+
+	expectStatus     statusRanges
+	bodyAssertions   *bodyAssertions
+	AssertionFailure string
+
+	audits       []string
+	auditHeaders []map[string]string
+	Findings     []finding
+
+	plainHTTPSeverity  string
+	checkHTTPSRedirect bool
 }
 
 type endpointSorter []endpoint
@@ -73,18 +204,28 @@ func numberOfTotalURLs(sites []site) (count int) {
 	return count
 }
 
-func checkSites(sites []site) {
+// checkSites checks all endpoints of the sites with at most concurrency
+// endpoints at a time. Every site is fed to the workers by its own goroutine,
+// so a throttled site does not hold up the others. Endpoints that are still
+// being checked when ctx expires are recorded as timed out.
+func checkSites(ctx context.Context, sites []site, concurrency int) {
 	amountOfURLs := numberOfTotalURLs(sites)
 	endpointChan := make(chan *endpoint, amountOfURLs)
 	endpointDone := make(chan bool, amountOfURLs)
 	defer close(endpointChan)
 	defer close(endpointDone)
-	for i := 0; i < 30; i++ {
-		go endpointWorker(endpointChan, endpointDone)
+	if concurrency > amountOfURLs {
+		concurrency = amountOfURLs
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for i := 0; i < concurrency; i++ {
+		go endpointWorker(ctx, endpointChan, endpointDone)
 	}
 
 	for i := range sites {
-		checkSite(&sites[i], endpointChan)
+		go checkSite(ctx, &sites[i], endpointChan)
 	}
 	// Wait for all endpoints to be done
 	for i := 0; i < amountOfURLs; i++ {
@@ -98,124 +239,545 @@ func printSitesTable(sites []site, statusCode int) {
 	table.SetBorders(tablewriter.Border{Left: false, Top: false, Right: false, Bottom: true})
 	table.SetAlignment(tablewriter.ALIGN_LEFT)
 	table.SetAutoFormatHeaders(false)
-	table.SetHeader([]string{"URL", "Basic Auth", "Wanted BA", "Success", "HTTP Status"})
+	table.SetHeader([]string{"URL", "Basic Auth", "Wanted BA", "Wanted Scheme", "Scheme", "Challenge", "Credentials", "Success", "HTTP Status", "TLS"})
 	for _, site := range sites {
 		sort.Sort(endpointSorter(site.endpoints))
 		for _, ep := range site.endpoints {
-			baMessage := "no"
-			baWantedMessage := "no"
-			if ep.BaEnabled {
-				baMessage = "yes"
-			}
-			if ep.Unknown {
-				baMessage = "unknown"
-			}
-			if ep.BaShouldBe {
-				baWantedMessage = "yes"
-			}
 			data := []string{
-				ep.URL,
-				baMessage,
-				baWantedMessage,
-				strconv.FormatBool(ep.Success),
-				ep.HTTPStatus,
+				urlMessage(ep),
+				baMessage(ep),
+				baWantedMessage(ep),
+				wantedSchemeMessage(ep),
+				ep.AuthScheme,
+				challengeMessage(ep),
+				credentialsMessage(ep),
+				successMessage(ep),
+				httpStatusMessage(ep),
+				tlsMessage(ep),
 			}
 			table.Append(data)
 		}
 	}
 	table.Render()
+	printFindingsTable(sites)
+	printSiteStatus(sites)
 	fmt.Printf("\nStatus: %s\n", lookUpStatusCodeMap[statusCode])
 }
 
-func printNagiosResult(sites []site, statusCode int) {
-	totalURLs := numberOfTotalURLs(sites)
-	failures, unknowns := getTotalFailuresAndUnknowns(sites)
-	if unknowns > 0 {
-		fmt.Printf("BA check: %s - OK: %d/%d Unknowns: %d\n",
-			lookUpStatusCodeMap[statusCode],
-			totalURLs-(failures+unknowns),
-			totalURLs,
-			unknowns)
-	} else {
-		fmt.Printf("BA check: %s - OK: %d/%d\n",
-			lookUpStatusCodeMap[statusCode],
-			totalURLs-failures,
-			totalURLs)
+// printSiteStatus lists the status of every site below the results
+func printSiteStatus(sites []site) {
+	width := 0
+	for _, site := range sites {
+		if len(site.Base) > width {
+			width = len(site.Base)
+		}
+	}
+	fmt.Println("\nSite status:")
+	for _, site := range sites {
+		fmt.Printf("  %-*s  %s\n", width, site.Base, lookUpStatusCodeMap[site.status])
 	}
 }
 
-func printResults(sites []site, outputFormat string, statusCode int) {
+// baMessage describes whether Basic Auth was found, or why it is not known
+func baMessage(ep endpoint) string {
+	switch {
+	case ep.Error:
+		return "error"
+	case ep.Unknown:
+		return "unknown"
+	case ep.BaEnabled:
+		return "yes"
+	}
+	return "no"
+}
+
+func baWantedMessage(ep endpoint) string {
+	if ep.BaShouldBe {
+		return "yes"
+	}
+	return "no"
+}
+
+// httpStatusMessage is the HTTP status, or the error, followed by the
+// redirect and the failed assertion if there are any
+func httpStatusMessage(ep endpoint) string {
+	httpStatus := ep.HTTPStatus
+	if ep.Error {
+		httpStatus = fmt.Sprintf("%s error: %s", ep.ErrorCategory, ep.ErrorMessage)
+	} else if message := redirectMessage(ep); message != "" {
+		httpStatus = fmt.Sprintf("%s (%s)", httpStatus, message)
+	}
+	if ep.AssertionFailure != "" {
+		httpStatus = fmt.Sprintf("%s (%s)", httpStatus, ep.AssertionFailure)
+	}
+	return httpStatus
+}
+
+// wantedSchemeMessage describes the scheme(s) the endpoint is expected to use
+func wantedSchemeMessage(ep endpoint) string {
+	if !ep.BaShouldBe {
+		return schemeNone
+	}
+	schemes := acceptedSchemes(ep)
+	if ep.ExpectRedirect != "" && ep.ExpectScheme != schemeRedirect {
+		schemes = append(schemes, schemeRedirect)
+	}
+	return strings.Join(schemes, "|")
+}
+
+// urlMessage prefixes the URL with the method, unless it is a plain GET
+func urlMessage(ep endpoint) string {
+	if ep.Method == "" || ep.Method == "GET" {
+		return ep.URL
+	}
+	return fmt.Sprintf("%s %s", ep.Method, ep.URL)
+}
+
+// successMessage also shows when it took more than one attempt to get there
+func successMessage(ep endpoint) string {
+	if ep.Attempts > 1 {
+		return fmt.Sprintf("%t (%d attempts)", ep.Success, ep.Attempts)
+	}
+	return strconv.FormatBool(ep.Success)
+}
+
+// challengeMessage tells whether a wanted challenge was received
+func challengeMessage(ep endpoint) string {
+	switch {
+	case !ep.BaShouldBe || ep.Error:
+		return ""
+	case ep.ExpectRedirect != "" && ep.Redirected:
+		return "redirect ok"
+	case ep.ExpectRedirect != "" && ep.ExpectScheme == schemeRedirect:
+		return "missing redirect"
+	case ep.ExpectRedirect != "" && !ep.BaEnabled:
+		return "missing challenge or redirect"
+	case ep.BaEnabled && ep.ExpectRealm != "" && !matchRealm(ep.ExpectRealm, ep.Realm):
+		return fmt.Sprintf("wrong realm %q", ep.Realm)
+	case ep.BaEnabled:
+		return "ok"
+	}
+	return "missing"
+}
+
+func credentialsMessage(ep endpoint) string {
+	var messages []string
+	switch {
+	case ep.CredentialsSkipped:
+		messages = append(messages, ep.CredentialsStatus)
+	case !ep.CredentialsChecked:
+	case ep.CredentialsOK:
+		messages = append(messages, "ok")
+	default:
+		messages = append(messages, fmt.Sprintf("rejected (%s)", ep.CredentialsStatus))
+	}
+	if ep.AcceptsArbitraryCredentials {
+		messages = append(messages, fmt.Sprintf("accepts arbitrary credentials (%s)", ep.InvalidCredentialsStatus))
+	}
+	if ep.InvalidCredentialsError {
+		messages = append(messages, fmt.Sprintf("invalid credentials not checked (%s)", ep.InvalidCredentialsStatus))
+	}
+	return strings.Join(messages, ", ")
+}
+
+func validOutputFormat(outputFormat string) bool {
+	for _, format := range outputFormats {
+		if outputFormat == format {
+			return true
+		}
+	}
+	return false
+}
+
+func printResults(sites []site, outputFormat string, statusCode int, nagiosMaxBytes int, started time.Time, finished time.Time) {
 	switch {
 	case outputFormat == "table":
 		printSitesTable(sites, statusCode)
 		return
 	case outputFormat == "nagios":
-		printNagiosResult(sites, statusCode)
+		printNagiosResult(sites, statusCode, nagiosMaxBytes)
+		return
+	case outputFormat == "json":
+		printJSONResult(sites, statusCode, started, finished)
+		return
+	case outputFormat == "junit":
+		printJUnitResult(sites, started)
+		return
+	case outputFormat == "realms":
+		printRealmsReport(sites, statusCode)
 		return
 	}
-	fmt.Printf("Unkown output format: %s\n", outputFormat)
+	fmt.Printf("Unknown output format: %s\n", outputFormat)
 }
 
-func endpointWorker(endpointChan <-chan *endpoint, endpointDone chan bool) {
+func endpointWorker(ctx context.Context, endpointChan <-chan *endpoint, endpointDone chan bool) {
 	for ep := range endpointChan {
-		checkURL(ep)
+		if ctx.Err() != nil {
+			recordError(ep, fmt.Errorf("not started before the run deadline: %w", ctx.Err()))
+		} else {
+			checkURL(ctx, ep)
+		}
+		ep.limiter.release()
 		endpointDone <- true
 	}
 }
 
-func checkSite(site *site, endpointChan chan *endpoint) {
+func checkSite(ctx context.Context, site *site, endpointChan chan *endpoint) {
 	for index := range site.endpoints {
-		endpointChan <- &site.endpoints[index]
+		ep := &site.endpoints[index]
+		if err := ep.limiter.acquire(ctx); err != nil {
+			// Past the deadline, the worker records it as not started
+			ep.limiter = nil
+		}
+		endpointChan <- ep
 	}
 }
 
-func checkSuccess(response *http.Response, baShouldBe bool) (success bool, baEnabled bool, unknown bool) {
+// checkSuccess only counts a 401 as Basic Auth enabled when it carries a
+// challenge for one of authSchemes (Basic if none are given). A 401 without
+// any challenge at all is reported as unknown.
+func checkSuccess(response *http.Response, baShouldBe bool, authSchemes []string) (success bool, baEnabled bool, unknown bool) {
+	if len(authSchemes) == 0 {
+		authSchemes = []string{schemeBasic}
+	}
 	if response.StatusCode == 401 {
-		baEnabled = true
+		challenges := parseChallenges(response.Header["Www-Authenticate"])
+		if len(challenges) == 0 {
+			return false, false, true
+		}
+		_, baEnabled = findScheme(challenges, authSchemes)
+		if !baShouldBe {
+			// Protected by some other scheme is still not publicly available
+			return false, baEnabled, false
+		}
 	} else if response.StatusCode > 401 {
 		unknown = true
 	}
 	return baEnabled == baShouldBe, baEnabled, unknown
 }
 
-func checkURL(ep *endpoint) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", ep.URL, nil)
+// classifyError maps a request error to one of the error categories
+func classifyError(err error) string {
+	var (
+		dnsErr       *net.DNSError
+		netErr       net.Error
+		opErr        *net.OpError
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		certErr      x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &dnsErr):
+		return errorDNS
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return errorTimeout
+	case errors.As(err, &recordErr),
+		errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &certErr),
+		strings.Contains(err.Error(), "tls: "):
+		return errorTLS
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return errorConnect
+	}
+	return errorProtocol
+}
+
+// recordError marks the endpoint as not checkable, keeping the reason
+func recordError(ep *endpoint, err error) {
+	ep.Success = false
+	ep.BaEnabled = false
+	ep.Error = true
+	ep.ErrorCategory = classifyError(err)
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	ep.ErrorMessage = err.Error()
+}
+
+// newRequest builds the request for the endpoint, with its method, headers
+// and body. Configured headers override the default ones.
+func newRequest(ctx context.Context, ep *endpoint) (*http.Request, error) {
+	method := ep.Method
+	if method == "" {
+		method = "GET"
+	}
+	var body io.Reader
+	if ep.body != "" {
+		body = strings.NewReader(ep.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, ep.URL, body)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Add("Cache-Control", "no-cache")
 	req.Header.Set("User-Agent", fmt.Sprintf("ba_checker %s", toolVersion))
-	response, err := client.Do(req)
+	for name, value := range ep.headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
 
+// mergeHeaders returns the site headers overridden by the endpoint headers
+func mergeHeaders(siteHeaders map[string]string, endpointHeaders map[string]string) map[string]string {
+	if len(siteHeaders) == 0 {
+		return endpointHeaders
+	}
+	headers := map[string]string{}
+	for name, value := range siteHeaders {
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+	for name, value := range endpointHeaders {
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+	return headers
+}
+
+func checkURL(ctx context.Context, ep *endpoint) {
+	if ep.ExpectScheme == schemeMTLS {
+		checkMTLS(ctx, ep)
+		return
+	}
+	response, err := requestWithRetries(ctx, ep)
 	if err != nil {
-		ep.Success = false
-		ep.BaEnabled = false
+		recordError(ep, err)
+		return
 	}
+	defer response.Body.Close()
+
 	ep.HTTPStatusCode = response.StatusCode
 	ep.HTTPStatus = response.Status
-	ep.Success, ep.BaEnabled, ep.Unknown = checkSuccess(response, ep.BaShouldBe)
+	ep.Challenges = parseChallenges(response.Header["Www-Authenticate"])
+	ep.Redirects = redirectChain(response)
+	recordTLS(ep, response)
+	if response.StatusCode == 401 {
+		ep.AuthScheme = challengeSchemes(ep.Challenges)
+	}
+	ep.Success, ep.BaEnabled, ep.Unknown = checkSuccess(response, ep.BaShouldBe, acceptedSchemes(*ep))
+	if c, ok := findScheme(ep.Challenges, acceptedSchemes(*ep)); ok {
+		ep.Realm = c.Realm
+	}
+	if ep.BaEnabled && ep.ExpectRealm != "" && !matchRealm(ep.ExpectRealm, ep.Realm) {
+		ep.Success = false
+	}
+	if ep.ExpectRedirect != "" {
+		checkRedirectExpectation(ep)
+	}
+	checkAssertions(ep, response)
+	checkPlainHTTP(ctx, ep, response)
+	if ep.BaEnabled && ep.credentials != nil {
+		checkCredentials(ctx, ep)
+		if ep.CredentialsChecked && !ep.CredentialsOK {
+			ep.Success = false
+		}
+	}
+	if ep.BaEnabled && ep.checkInvalid {
+		checkInvalidCredentials(ctx, ep)
+	}
+	if ep.BaEnabled && len(ep.audits) > 0 {
+		runAudits(ctx, ep)
+	}
+}
+
+// acceptedSchemes returns the schemes that count as the endpoint being
+// protected: the expected scheme if given, otherwise the site's auth_schemes
+func acceptedSchemes(ep endpoint) []string {
+	if ep.ExpectScheme != "" && ep.ExpectScheme != schemeNone {
+		return []string{ep.ExpectScheme}
+	}
+	if len(ep.AuthSchemes) > 0 {
+		return ep.AuthSchemes
+	}
+	return []string{schemeBasic}
 }
 
-func populateURLConfig(sites []site) {
+func validExpectScheme(scheme string) bool {
+	for _, valid := range validExpectSchemes {
+		if scheme == valid {
+			return true
+		}
+	}
+	return false
+}
+
+func populateURLConfig(sites []site) error {
 	for index := range sites {
+		if err := loadRedirectPolicy(&sites[index]); err != nil {
+			return fmt.Errorf("site %s: %s", sites[index].Base, err)
+		}
+		if err := loadSiteTLS(&sites[index]); err != nil {
+			return fmt.Errorf("site %s: %s", sites[index].Base, err)
+		}
+		client := newSiteClient(&sites[index])
+		limiter := newSiteLimiter(&sites[index])
+		if sites[index].Retry != nil {
+			if err := loadRetryPolicy(sites[index].Retry); err != nil {
+				return fmt.Errorf("site %s: %s", sites[index].Base, err)
+			}
+		}
+		if err := validateAudits(sites[index].Audits); err != nil {
+			return fmt.Errorf("site %s: %s", sites[index].Base, err)
+		}
+		if _, err := patternRegexp(sites[index].Realm); err != nil {
+			return fmt.Errorf("site %s: invalid realm: %s", sites[index].Base, err)
+		}
+		if err := loadPlainHTTPSeverity(&sites[index]); err != nil {
+			return fmt.Errorf("site %s: %s", sites[index].Base, err)
+		}
+		if sites[index].Credentials != nil {
+			if err := loadCredentials(sites[index].Credentials); err != nil {
+				return fmt.Errorf("site %s: %s", sites[index].Base, err)
+			}
+		}
+		// With the site's redirect_to, the auth endpoints accept either a
+		// challenge or the redirect, as the auth list can't tell them apart
 		for _, baURL := range sites[index].BasicAuth {
 			sites[index].endpoints = append(sites[index].endpoints,
 				endpoint{
-					BaShouldBe: true,
-					URL:        fmt.Sprintf("%s/%s", sites[index].Base, baURL),
+					BaShouldBe:               true,
+					Method:                   "GET",
+					URL:                      fmt.Sprintf("%s/%s", sites[index].Base, baURL),
+					Path:                     baURL,
+					ExpectRealm:              sites[index].Realm,
+					ExpectRedirect:           sites[index].RedirectTo,
+					AuthSchemes:              sites[index].AuthSchemes,
+					credentials:              sites[index].Credentials,
+					allowInsecureCredentials: sites[index].AllowInsecureCredentials,
+					checkInvalid:             sites[index].CheckInvalid,
+					audits:                   sites[index].Audits,
+					auditHeaders:             sites[index].AuditHeaders,
+					client:                   client,
+					limiter:                  limiter,
+					retry:                    sites[index].Retry,
+					headers:                  sites[index].Headers,
+
+					plainHTTPSeverity:  sites[index].PlainHTTPSeverity,
+					checkHTTPSRedirect: sites[index].CheckHTTPSRedirect,
 				})
 		}
 		for _, URL := range sites[index].NoBasicAuth {
 			sites[index].endpoints = append(sites[index].endpoints,
 				endpoint{
-					BaShouldBe: false,
-					URL:        fmt.Sprintf("%s/%s", sites[index].Base, URL),
+					BaShouldBe:   false,
+					Method:       "GET",
+					URL:          fmt.Sprintf("%s/%s", sites[index].Base, URL),
+					Path:         URL,
+					AuthSchemes:  sites[index].AuthSchemes,
+					expectStatus: sites[index].ExpectStatus,
+					client:       client,
+					limiter:      limiter,
+					retry:        sites[index].Retry,
+					headers:      sites[index].Headers,
+
+					plainHTTPSeverity: sites[index].PlainHTTPSeverity,
+				})
+		}
+		for _, epConfig := range sites[index].Endpoints {
+			expectScheme := strings.ToLower(epConfig.ExpectScheme)
+			redirectTo := epConfig.RedirectTo
+			if redirectTo == "" && expectScheme == "" {
+				redirectTo = sites[index].RedirectTo
+			}
+			if redirectTo != "" {
+				if expectScheme != "" && expectScheme != schemeRedirect {
+					return fmt.Errorf("endpoint %s/%s: expect_scheme %q can't be combined with redirect_to",
+						sites[index].Base, epConfig.Path, epConfig.ExpectScheme)
+				}
+				if _, err := patternRegexp(redirectTo); err != nil {
+					return fmt.Errorf("endpoint %s/%s: invalid redirect_to: %s", sites[index].Base, epConfig.Path, err)
+				}
+				expectScheme = schemeRedirect
+			} else if expectScheme == schemeRedirect {
+				return fmt.Errorf("endpoint %s/%s: expect_scheme %q requires redirect_to",
+					sites[index].Base, epConfig.Path, epConfig.ExpectScheme)
+			}
+			if expectScheme == "" {
+				expectScheme = schemeBasic
+			}
+			if expectScheme != schemeRedirect && !validExpectScheme(expectScheme) {
+				return fmt.Errorf("invalid expect_scheme %q for %s/%s, valid schemes are: %s",
+					epConfig.ExpectScheme, sites[index].Base, epConfig.Path, strings.Join(validExpectSchemes, ", "))
+			}
+			epCredentials := sites[index].Credentials
+			if epConfig.Credentials != nil {
+				if err := loadCredentials(epConfig.Credentials); err != nil {
+					return fmt.Errorf("endpoint %s/%s: %s", sites[index].Base, epConfig.Path, err)
+				}
+				epCredentials = epConfig.Credentials
+			}
+			if expectScheme == schemeNone || expectScheme == schemeMTLS {
+				epCredentials = nil
+			}
+			epClient := client
+			if expectScheme == schemeMTLS {
+				epClient = newClientWithoutCert(&sites[index])
+			}
+			realm := sites[index].Realm
+			if epConfig.Realm != "" {
+				realm = epConfig.Realm
+			}
+			if _, err := patternRegexp(realm); err != nil {
+				return fmt.Errorf("endpoint %s/%s: invalid realm: %s", sites[index].Base, epConfig.Path, err)
+			}
+			var epAudits []string
+			if expectScheme != schemeNone {
+				epAudits = sites[index].Audits
+			}
+			expectStatus := epConfig.ExpectStatus
+			if expectStatus == nil && expectScheme == schemeNone {
+				expectStatus = sites[index].ExpectStatus
+			}
+			assertions, err := loadBodyAssertions(epConfig)
+			if err != nil {
+				return fmt.Errorf("endpoint %s/%s: %s", sites[index].Base, epConfig.Path, err)
+			}
+			method := strings.ToUpper(epConfig.Method)
+			if method == "" {
+				method = "GET"
+			}
+			sites[index].endpoints = append(sites[index].endpoints,
+				endpoint{
+					BaShouldBe:               expectScheme != schemeNone,
+					Method:                   method,
+					URL:                      fmt.Sprintf("%s/%s", sites[index].Base, epConfig.Path),
+					Path:                     epConfig.Path,
+					ExpectScheme:             expectScheme,
+					ExpectRealm:              realm,
+					ExpectRedirect:           redirectTo,
+					AuthSchemes:              sites[index].AuthSchemes,
+					credentials:              epCredentials,
+					allowInsecureCredentials: sites[index].AllowInsecureCredentials,
+					checkInvalid:             expectScheme != schemeNone && (sites[index].CheckInvalid || epConfig.CheckInvalid),
+					expectStatus:             expectStatus,
+					bodyAssertions:           assertions,
+					audits:                   epAudits,
+					auditHeaders:             sites[index].AuditHeaders,
+					client:                   epClient,
+					limiter:                  limiter,
+					retry:                    sites[index].Retry,
+					headers:                  mergeHeaders(sites[index].Headers, epConfig.Headers),
+					body:                     epConfig.Body,
+
+					plainHTTPSeverity:  sites[index].PlainHTTPSeverity,
+					checkHTTPSRedirect: expectScheme != schemeNone && sites[index].CheckHTTPSRedirect,
 				})
 		}
 	}
+	return nil
 }
 
 func getTotalFailuresAndUnknowns(sites []site) (failures int, unknowns int) {
 	for _, site := range sites {
 		for _, ep := range site.endpoints {
+			if ep.Error {
+				continue
+			}
 			if !ep.Success {
 				failures++
 			}
@@ -227,17 +789,98 @@ func getTotalFailuresAndUnknowns(sites []site) (failures int, unknowns int) {
 	return failures, unknowns
 }
 
-func checkStatus(sites []site, warningThreshold int, criticalThreshold int) (status int) {
+// getTotalOK counts the endpoints that are neither failed, unknown nor
+// errored, and have nothing else raising the status
+func getTotalOK(sites []site) (okCount int) {
+	for _, site := range sites {
+		for _, ep := range site.endpoints {
+			if ep.Success && !ep.Error && !ep.Unknown && len(ep.Findings) == 0 && !ep.AcceptsArbitraryCredentials {
+				okCount++
+			}
+		}
+	}
+	return okCount
+}
+
+func getTotalErrors(sites []site) (errorCount int) {
+	for _, site := range sites {
+		for _, ep := range site.endpoints {
+			if ep.Error {
+				errorCount++
+			}
+		}
+	}
+	return errorCount
+}
+
+func getTotalArbitraryCredentials(sites []site) (count int) {
+	for _, site := range sites {
+		for _, ep := range site.endpoints {
+			if ep.AcceptsArbitraryCredentials {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// statusSeverity orders the status codes, UNKNOWN ranks between WARNING and CRITICAL
+var statusSeverity = map[int]int{0: 0, 1: 1, 3: 2, 2: 3}
+
+// worseStatus returns the more severe of two status codes
+func worseStatus(a int, b int) int {
+	if statusSeverity[b] > statusSeverity[a] {
+		return b
+	}
+	return a
+}
+
+// checkStatus records the status of every site, using the thresholds of the
+// site when it has its own, and returns the worst of them
+func checkStatus(sites []site, warning threshold, critical threshold) (status int) {
+	for i := range sites {
+		siteWarning, siteCritical := warning, critical
+		if sites[i].Warning != nil {
+			siteWarning = *sites[i].Warning
+		}
+		if sites[i].Critical != nil {
+			siteCritical = *sites[i].Critical
+		}
+		sites[i].status = sitesStatus(sites[i:i+1], siteWarning, siteCritical)
+		status = worseStatus(status, sites[i].status)
+	}
+	return status
+}
+
+// sitesStatus is the status of the sites as a whole
+func sitesStatus(sites []site, warning threshold, critical threshold) (status int) {
 	failures, unknowns := getTotalFailuresAndUnknowns(sites)
+	errorCount := getTotalErrors(sites)
+	totalURLs := numberOfTotalURLs(sites)
 	switch {
-	case failures >= criticalThreshold:
-		return 2
+	case critical.alert(failures, totalURLs):
+		status = 2
+	case errorCount > 0:
+		status = 3
 	case unknowns > 0 && failures != 0:
-		return 3
-	case failures >= warningThreshold:
-		return 1
+		status = 3
+	case warning.alert(failures, totalURLs):
+		status = 1
 	}
-	return
+	if getTotalArbitraryCredentials(sites) > 0 {
+		// Accepting any credentials is never fine, whatever the thresholds
+		status = worseStatus(status, 1)
+	}
+	return worseStatus(status, findingsStatus(sites))
+}
+
+// loadConfig reads and decodes the TOML config file
+func loadConfig(configFile string) (config configuration, err error) {
+	if _, err := os.Stat(configFile); os.IsNotExist(err) {
+		return config, fmt.Errorf("Given config file %s does not exist", configFile)
+	}
+	_, err = toml.DecodeFile(configFile, &config)
+	return config, err
 }
 
 func main() {
@@ -245,43 +888,133 @@ func main() {
 
 Status can be determined by Exit codes:
  0=Status OK
- 1=Above warning threshold
- 2=Above critical threshold
- 3=Unknown Basic Auth status (4xx or 5xx HTTP codes)`)
+ 1=Failed URLs outside the warning range
+ 2=Failed URLs outside the critical range
+ 3=Unknown Basic Auth status (4xx or 5xx HTTP codes) or connection errors`)
 	app.Version("v version", toolVersion)
-	app.Spec = "[--warning=<number>] [--critical=<number>] [--output=<table|nagios>] [--no-spinner] CONFIGFILE"
+	app.Spec = "[--warning=<range>] [--critical=<range>] [--output=<table|nagios|json|junit|realms>] [--timeout=<duration>] [--deadline=<duration>] [--concurrency=<number>] [--nagios-max-bytes=<number>] [--audit=<audit>]... [--no-spinner] [CONFIGFILE]"
 
 	var (
-		noSpinner         = app.BoolOpt("no-spinner", false, "Disable spinner animation")
-		configFile        = app.StringArg("CONFIGFILE", "", "Config file")
-		outputFormat      = app.StringOpt("o output", "table", "Output format, available formats: table, nagios")
-		warningThreshold  = app.IntOpt("w warning", 1, "Warning threshold")
-		criticalThreshold = app.IntOpt("c critical", 2, "Critical threshold")
+		noSpinner      = app.BoolOpt("no-spinner", false, "Disable spinner animation")
+		configFile     = app.StringArg("CONFIGFILE", "", "Config file")
+		outputFormat   = app.StringOpt("o output", "table", "Output format, available formats: "+strings.Join(outputFormats, ", "))
+		warningRange   = app.StringOpt("w warning", "0", "Warning threshold, a Nagios range of failed URLs per site, or of their percentage with %")
+		criticalRange  = app.StringOpt("c critical", "1", "Critical threshold, a Nagios range of failed URLs per site, or of their percentage with %")
+		timeout        = app.StringOpt("t timeout", "10s", "Timeout per request, unless set for the site in the config")
+		deadline       = app.StringOpt("d deadline", "0s", "Deadline for the whole run, requests still running are reported as timed out (0s for none)")
+		concurrency    = app.IntOpt("concurrency", 30, "Number of endpoints checked at the same time")
+		nagiosMaxBytes = app.IntOpt("nagios-max-bytes", defaultNagiosMaxBytes, "Maximum size of the nagios output, the list of failed endpoints is cut to fit (0 for no limit)")
+		audits         = app.StringsOpt("audit", []string{}, "Audit protected endpoints for ways around the authentication, available audits: "+strings.Join(validAudits, ", "))
 	)
 
+	app.Command("serve", "Run the checks on an interval and expose the results as Prometheus metrics", func(cmd *cli.Cmd) {
+		cmd.Spec = "[--listen=<address>] [--interval=<duration>] [--timeout=<duration>] [--concurrency=<number>] [CONFIGFILE]"
+		var (
+			configFile  = cmd.StringArg("CONFIGFILE", "", "Config file, without it only /probe is served")
+			listen      = cmd.StringOpt("l listen", ":9612", "Address to listen on")
+			interval    = cmd.StringOpt("i interval", "60s", "Time between check runs, also the deadline of each run")
+			timeout     = cmd.StringOpt("t timeout", "10s", "Timeout per request, unless set for the site in the config")
+			concurrency = cmd.IntOpt("concurrency", 30, "Number of endpoints checked at the same time")
+		)
+		cmd.Action = func() {
+			checkInterval, err := time.ParseDuration(*interval)
+			if err != nil {
+				fmt.Println("Error: invalid interval:", err)
+				cli.Exit(1)
+			}
+			requestTimeout, err := time.ParseDuration(*timeout)
+			if err != nil {
+				fmt.Println("Error: invalid timeout:", err)
+				cli.Exit(1)
+			}
+			var config *configuration
+			if *configFile != "" {
+				loaded, err := loadConfig(*configFile)
+				if err != nil {
+					fmt.Println("Error:", err)
+					cli.Exit(1)
+				}
+				setDefaultTimeout(loaded.Sites, requestTimeout)
+				if _, err := newSites(loaded); err != nil {
+					fmt.Println("Error:", err)
+					cli.Exit(1)
+				}
+				config = &loaded
+			}
+			if err := serve(config, *listen, checkInterval, requestTimeout, *concurrency); err != nil {
+				fmt.Println("Error:", err)
+				cli.Exit(1)
+			}
+		}
+	})
+
 	app.Action = func() {
-		var config configuration
-		if _, err := os.Stat(*configFile); os.IsNotExist(err) {
-			fmt.Printf("Error: Given config file %s does not exist, exiting..\n", *configFile)
+		if *configFile == "" {
+			fmt.Println("Error: CONFIGFILE is required")
+			app.PrintHelp()
+			cli.Exit(1)
+		}
+		if !validOutputFormat(*outputFormat) {
+			fmt.Printf("Error: Unknown output format %s, available formats: %s\n", *outputFormat, strings.Join(outputFormats, ", "))
+			cli.Exit(1)
+		}
+		requestTimeout, err := time.ParseDuration(*timeout)
+		if err != nil {
+			fmt.Println("Error: invalid timeout:", err)
 			cli.Exit(1)
 		}
-		if _, err := toml.DecodeFile(*configFile, &config); err != nil {
+		runDeadline, err := time.ParseDuration(*deadline)
+		if err != nil {
+			fmt.Println("Error: invalid deadline:", err)
+			cli.Exit(1)
+		}
+		warning, err := parseThreshold(*warningRange)
+		if err != nil {
+			fmt.Println("Error: invalid warning:", err)
+			cli.Exit(1)
+		}
+		critical, err := parseThreshold(*criticalRange)
+		if err != nil {
+			fmt.Println("Error: invalid critical:", err)
+			cli.Exit(1)
+		}
+		config, err := loadConfig(*configFile)
+		if err != nil {
 			fmt.Println("Error:", err)
 			cli.Exit(1)
 		}
+		if err := validateAudits(*audits); err != nil {
+			fmt.Println("Error:", err)
+			cli.Exit(1)
+		}
+		setDefaultTimeout(config.Sites, requestTimeout)
+		enableAudits(config.Sites, *audits)
+		if err := populateURLConfig(config.Sites); err != nil {
+			fmt.Println("Error:", err)
+			cli.Exit(1)
+		}
+		// The spinner would end up in machine readable output
+		showSpinner := !*noSpinner && *outputFormat != "json" && *outputFormat != "junit"
 		s := spinner.New(spinner.CharSets[7], 100*time.Millisecond)
-		if !*noSpinner {
+		if showSpinner {
 			s.Prefix = "running tests "
 			s.Start()
 		}
-		populateURLConfig(config.Sites)
-		checkSites(config.Sites)
+		ctx := context.Background()
+		if runDeadline > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, runDeadline)
+			defer cancel()
+		}
+		started := time.Now()
+		checkSites(ctx, config.Sites, *concurrency)
+		finished := time.Now()
 
-		if !*noSpinner {
+		if showSpinner {
 			s.Stop()
 		}
-		lookupStatusCode := checkStatus(config.Sites, *warningThreshold, *criticalThreshold)
-		printResults(config.Sites, *outputFormat, lookupStatusCode)
+		lookupStatusCode := checkStatus(config.Sites, warning, critical)
+		printResults(config.Sites, *outputFormat, lookupStatusCode, *nagiosMaxBytes, started, finished)
 		if lookupStatusCode > 0 {
 			cli.Exit(lookupStatusCode)
 		}
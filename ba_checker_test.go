@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
 )
 
 type testCase struct {
@@ -35,13 +44,14 @@ func TestCheckSuccess(t *testing.T) {
 		for _, ep := range site.endpoints {
 			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				if ep.BaShouldBe {
+					w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
 					w.WriteHeader(http.StatusUnauthorized)
 				} else {
 					w.WriteHeader(http.StatusOK)
 				}
 			}))
 			response, _ := http.Get(ts.URL)
-			ep.Success, ep.BaEnabled, ep.Unknown = checkSuccess(response, ep.BaShouldBe)
+			ep.Success, ep.BaEnabled, ep.Unknown = checkSuccess(response, ep.BaShouldBe, ep.AuthSchemes)
 			if !ep.Success {
 				t.Error("No success! Expected success!")
 			}
@@ -52,7 +62,7 @@ func TestCheckSuccess(t *testing.T) {
 		}
 		for _, ep := range site.endpoints {
 			response, _ := http.Get(ep.URL)
-			ep.Success, ep.BaEnabled, ep.Unknown = checkSuccess(response, ep.BaShouldBe)
+			ep.Success, ep.BaEnabled, ep.Unknown = checkSuccess(response, ep.BaShouldBe, ep.AuthSchemes)
 			if !ep.Success {
 				t.Logf("Tested URL: %s Response BA: %t Expected BA: %t", ep.URL, response.StatusCode == 401, ep.BaShouldBe)
 				t.Error("No success! Expected success!")
@@ -72,7 +82,7 @@ func TestCheckSuccessFail(t *testing.T) {
 	defer ts.Close()
 	baShouldBe := true
 	response, _ := http.Get(ts.URL)
-	success, baEnabled, _ := checkSuccess(response, baShouldBe)
+	success, baEnabled, _ := checkSuccess(response, baShouldBe, nil)
 	if success {
 		t.Error("Success?! Expected failure!")
 	}
@@ -85,7 +95,7 @@ func TestCheckURL(t *testing.T) {
 	sites := getTestSites()
 	for _, site := range sites {
 		for index := range site.endpoints {
-			checkURL(&site.endpoints[index])
+			checkURL(context.Background(), &site.endpoints[index])
 			if !site.endpoints[index].Success {
 				t.Errorf("Expected 'success' to be true, got false")
 			}
@@ -98,7 +108,9 @@ func TestGetMaxWidth(t *testing.T) {
 		sites:    getTestSites(),
 		maxWidth: 66,
 	}
-	populateURLConfig(tc.sites)
+	if err := populateURLConfig(tc.sites); err != nil {
+		t.Fatal(err)
+	}
 	got := getMaxWidth(tc.sites)
 	if got != tc.maxWidth {
 		t.Errorf("Incorrect maxWidth %d, wanted %d", got, tc.maxWidth)
@@ -110,9 +122,277 @@ func TestNumberOfTotalURL(t *testing.T) {
 		sites:      getTestSites(),
 		totalCount: 6,
 	}
-	populateURLConfig(tc.sites)
+	if err := populateURLConfig(tc.sites); err != nil {
+		t.Fatal(err)
+	}
 	got := numberOfTotalURLs(tc.sites)
 	if got != tc.totalCount {
 		t.Errorf("Incorrect total URL count %d, wanted %d", got, tc.totalCount)
 	}
 }
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	testCases := []struct {
+		err      error
+		category string
+	}{
+		{&url.Error{Op: "Get", URL: "http://nx.invalid", Err: &net.DNSError{Err: "no such host", Name: "nx.invalid"}}, errorDNS},
+		{&url.Error{Op: "Get", URL: "http://example.com", Err: timeoutError{}}, errorTimeout},
+		{&url.Error{Op: "Get", URL: "http://example.com", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}, errorConnect},
+		{&url.Error{Op: "Get", URL: "https://example.com", Err: x509.UnknownAuthorityError{}}, errorTLS},
+		{&url.Error{Op: "Get", URL: "http://example.com", Err: errors.New("malformed HTTP response")}, errorProtocol},
+	}
+	for _, tc := range testCases {
+		if got := classifyError(tc.err); got != tc.category {
+			t.Errorf("Incorrect category %q for %v, wanted %q", got, tc.err, tc.category)
+		}
+	}
+}
+
+func TestCheckURLConnectionError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ep := endpoint{URL: ts.URL, BaShouldBe: true}
+	ts.Close()
+	checkURL(context.Background(), &ep)
+	if !ep.Error {
+		t.Fatal("Expected endpoint to be in error state")
+	}
+	if ep.ErrorCategory != errorConnect {
+		t.Errorf("Incorrect error category %q, wanted %q", ep.ErrorCategory, errorConnect)
+	}
+	if ep.Success {
+		t.Error("Success?! Expected failure!")
+	}
+}
+
+func TestCheckURLTLSError(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	ep := endpoint{URL: ts.URL}
+	checkURL(context.Background(), &ep)
+	if !ep.Error || ep.ErrorCategory != errorTLS {
+		t.Errorf("Expected %q error, got error=%t category=%q", errorTLS, ep.Error, ep.ErrorCategory)
+	}
+}
+
+func TestCheckStatusErrors(t *testing.T) {
+	sites := []site{
+		site{
+			endpoints: []endpoint{
+				endpoint{Success: true},
+				endpoint{Error: true, ErrorCategory: errorDNS},
+			},
+		},
+	}
+	if status := checkStatus(sites, countThreshold(1), countThreshold(2)); status != 3 {
+		t.Errorf("Incorrect status %d, wanted 3", status)
+	}
+	failures, _ := getTotalFailuresAndUnknowns(sites)
+	if failures != 0 {
+		t.Errorf("Errors should not count as failures, got %d", failures)
+	}
+	sites[0].endpoints = append(sites[0].endpoints, endpoint{}, endpoint{})
+	if status := checkStatus(sites, countThreshold(1), countThreshold(2)); status != 2 {
+		t.Errorf("Incorrect status %d, wanted 2", status)
+	}
+}
+
+func TestCheckSuccessSchemes(t *testing.T) {
+	testCases := []struct {
+		header      string
+		baShouldBe  bool
+		authSchemes []string
+		success     bool
+		baEnabled   bool
+		unknown     bool
+	}{
+		{`Basic realm="test"`, true, nil, true, true, false},
+		{`Digest realm="test", nonce="abc"`, true, nil, false, false, false},
+		{`Digest realm="test", nonce="abc"`, true, []string{"basic", "digest"}, true, true, false},
+		{`Bearer realm="api"`, false, nil, false, false, false},
+		{"", true, nil, false, false, true},
+	}
+	for _, tc := range testCases {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tc.header != "" {
+				w.Header().Set("WWW-Authenticate", tc.header)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		response, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		success, baEnabled, unknown := checkSuccess(response, tc.baShouldBe, tc.authSchemes)
+		if success != tc.success || baEnabled != tc.baEnabled || unknown != tc.unknown {
+			t.Errorf("Challenge %q: got success=%t baEnabled=%t unknown=%t, wanted %t %t %t",
+				tc.header, success, baEnabled, unknown, tc.success, tc.baEnabled, tc.unknown)
+		}
+		response.Body.Close()
+		ts.Close()
+	}
+}
+
+func TestPopulateURLConfigEndpoints(t *testing.T) {
+	var config configuration
+	_, err := toml.Decode(`
+[[site]]
+base = "https://example.com"
+auth = ["admin"]
+no_auth = [""]
+
+[[site.endpoint]]
+path = "api"
+expect_scheme = "Bearer"
+
+[[site.endpoint]]
+path = "dav"
+expect_scheme = "digest"
+realm = "WebDAV"
+
+[[site.endpoint]]
+path = "public"
+expect_scheme = "none"
+
+[[site.endpoint]]
+path = "legacy"
+`, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := populateURLConfig(config.Sites); err != nil {
+		t.Fatal(err)
+	}
+	wanted := map[string]string{
+		"https://example.com/admin":  "basic",
+		"https://example.com/":       "none",
+		"https://example.com/api":    "bearer",
+		"https://example.com/dav":    "digest",
+		"https://example.com/public": "none",
+		"https://example.com/legacy": "basic",
+	}
+	if got := numberOfTotalURLs(config.Sites); got != len(wanted) {
+		t.Fatalf("Incorrect total URL count %d, wanted %d", got, len(wanted))
+	}
+	for _, ep := range config.Sites[0].endpoints {
+		if got := wantedSchemeMessage(ep); got != wanted[ep.URL] {
+			t.Errorf("Incorrect wanted scheme %q for %s, wanted %q", got, ep.URL, wanted[ep.URL])
+		}
+		if ep.URL == "https://example.com/dav" && ep.ExpectRealm != "WebDAV" {
+			t.Errorf("Incorrect expected realm %q", ep.ExpectRealm)
+		}
+	}
+
+	invalid := []site{
+		site{Base: "https://example.com", Endpoints: []endpointConfig{{Path: "x", ExpectScheme: "kerberos"}}},
+	}
+	if err := populateURLConfig(invalid); err == nil {
+		t.Error("Expected error for invalid expect_scheme")
+	}
+}
+
+func TestCheckURLExpectScheme(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Digest realm="WebDAV", nonce="abc", qop="auth"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+	testCases := []struct {
+		ep      endpoint
+		success bool
+	}{
+		{endpoint{URL: ts.URL, BaShouldBe: true}, false},
+		{endpoint{URL: ts.URL, BaShouldBe: true, ExpectScheme: schemeDigest}, true},
+		{endpoint{URL: ts.URL, BaShouldBe: true, ExpectScheme: schemeDigest, ExpectRealm: "WebDAV"}, true},
+		{endpoint{URL: ts.URL, BaShouldBe: true, ExpectScheme: schemeDigest, ExpectRealm: "Other"}, false},
+		{endpoint{URL: ts.URL, BaShouldBe: false, ExpectScheme: schemeNone}, false},
+	}
+	for _, tc := range testCases {
+		checkURL(context.Background(), &tc.ep)
+		if tc.ep.Success != tc.success {
+			t.Errorf("Expected scheme %q realm %q: got success %t, wanted %t",
+				tc.ep.ExpectScheme, tc.ep.ExpectRealm, tc.ep.Success, tc.success)
+		}
+		if tc.ep.AuthScheme != "Digest" {
+			t.Errorf("Incorrect scheme %q, wanted Digest", tc.ep.AuthScheme)
+		}
+	}
+}
+
+func TestWorseStatus(t *testing.T) {
+	testCases := []struct {
+		a, b, worse int
+	}{
+		{0, 1, 1},
+		{1, 3, 3},
+		{3, 2, 2},
+		{2, 1, 2},
+		{3, 1, 3},
+	}
+	for _, tc := range testCases {
+		if got := worseStatus(tc.a, tc.b); got != tc.worse {
+			t.Errorf("worseStatus(%d, %d) = %d, wanted %d", tc.a, tc.b, got, tc.worse)
+		}
+	}
+}
+
+func TestCheckURLMethodHeadersBody(t *testing.T) {
+	var method, body, host, token, accept string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		method, body, host = r.Method, string(data), r.Host
+		token, accept = r.Header.Get("X-Token"), r.Header.Get("Accept")
+		w.Header().Set("WWW-Authenticate", `Basic realm="api"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+	var config configuration
+	_, err := toml.Decode(fmt.Sprintf(`
+[[site]]
+base = "%s"
+
+[site.headers]
+Accept = "text/html"
+X-Token = "site"
+
+[[site.endpoint]]
+path = "graphql"
+method = "post"
+body = '{"query": "{ me { id } }"}'
+
+[site.endpoint.headers]
+accept = "application/json"
+Host = "api.example.com"
+`, ts.URL), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := populateURLConfig(config.Sites); err != nil {
+		t.Fatal(err)
+	}
+	ep := config.Sites[0].endpoints[0]
+	if ep.Method != "POST" {
+		t.Errorf("Incorrect method %q, wanted POST", ep.Method)
+	}
+	if got := urlMessage(ep); got != "POST "+ts.URL+"/graphql" {
+		t.Errorf("Incorrect URL message %q", got)
+	}
+	// Retried requests must send the body again
+	ep.retry = &retryPolicy{Attempts: 2, Backoff: duration{time.Millisecond}, Statuses: []int{401}}
+	checkURL(context.Background(), &ep)
+	if !ep.Success || ep.Attempts != 2 {
+		t.Errorf("Incorrect result: success %t, attempts %d", ep.Success, ep.Attempts)
+	}
+	if method != "POST" || body != `{"query": "{ me { id } }"}` {
+		t.Errorf("Incorrect request %s %q", method, body)
+	}
+	if host != "api.example.com" || token != "site" || accept != "application/json" {
+		t.Errorf("Incorrect headers: Host %q, X-Token %q, Accept %q", host, token, accept)
+	}
+}
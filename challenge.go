@@ -0,0 +1,175 @@
+package main
+
+import (
+	"strings"
+)
+
+const (
+	schemeBasic     = "basic"
+	schemeDigest    = "digest"
+	schemeBearer    = "bearer"
+	schemeNegotiate = "negotiate"
+	schemeNTLM      = "ntlm"
+)
+
+// challenge is a single authentication challenge from a WWW-Authenticate header
+type challenge struct {
+	Scheme  string
+	Realm   string
+	Charset string
+	Token   string
+	Params  map[string]string
+}
+
+// parseChallenges parses all WWW-Authenticate header values into challenges.
+// A header value may contain several comma separated challenges, see RFC 7235.
+func parseChallenges(headers []string) (challenges []challenge) {
+	for _, header := range headers {
+		challenges = append(challenges, parseChallengeHeader(header)...)
+	}
+	return challenges
+}
+
+func parseChallengeHeader(header string) (challenges []challenge) {
+	s := header
+	for {
+		s = skipSeparators(s)
+		if s == "" {
+			return challenges
+		}
+		var scheme string
+		scheme, s = readToken(s)
+		if scheme == "" {
+			// Not a valid challenge, skip ahead to the next separator
+			if i := strings.IndexByte(s, ','); i >= 0 {
+				s = s[i+1:]
+				continue
+			}
+			return challenges
+		}
+		c := challenge{Scheme: scheme, Params: map[string]string{}}
+		s = parseChallengeParams(&c, s)
+		c.Realm = c.Params["realm"]
+		c.Charset = c.Params["charset"]
+		challenges = append(challenges, c)
+	}
+}
+
+// parseChallengeParams reads either a token68 or a list of auth-params
+// following the scheme, and returns the remainder of the header which
+// starts at the next challenge.
+func parseChallengeParams(c *challenge, s string) string {
+	first := true
+	for {
+		rest := strings.TrimLeft(s, " \t")
+		if first && rest != "" && rest[0] != ',' {
+			if token, after, ok := readToken68(rest); ok {
+				c.Token = token
+				return after
+			}
+		}
+		rest = skipSeparators(rest)
+		name, after := readToken(rest)
+		after = strings.TrimLeft(after, " \t")
+		if name == "" || !strings.HasPrefix(after, "=") {
+			// Either the end of the header or the start of the next challenge
+			return rest
+		}
+		var value string
+		value, after = readParamValue(strings.TrimLeft(after[1:], " \t"))
+		c.Params[strings.ToLower(name)] = value
+		s = after
+		first = false
+	}
+}
+
+func skipSeparators(s string) string {
+	return strings.TrimLeft(s, " \t,")
+}
+
+func isTokenChar(b byte) bool {
+	switch {
+	case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", b) >= 0
+}
+
+func isToken68Char(b byte) bool {
+	switch {
+	case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+		return true
+	}
+	return strings.IndexByte("-._~+/", b) >= 0
+}
+
+func readToken(s string) (token string, rest string) {
+	i := 0
+	for i < len(s) && isTokenChar(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// readToken68 reads a token68 value, which is only valid when it is the
+// whole credential part of the challenge (followed by the end or a comma)
+func readToken68(s string) (token string, rest string, ok bool) {
+	i := 0
+	for i < len(s) && isToken68Char(s[i]) {
+		i++
+	}
+	if i == 0 {
+		return "", s, false
+	}
+	for i < len(s) && s[i] == '=' {
+		i++
+	}
+	rest = strings.TrimLeft(s[i:], " \t")
+	if rest != "" && rest[0] != ',' {
+		return "", s, false
+	}
+	return s[:i], rest, true
+}
+
+func readParamValue(s string) (value string, rest string) {
+	if !strings.HasPrefix(s, `"`) {
+		return readToken(s)
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	// Unterminated quoted string, use what we have
+	return b.String(), ""
+}
+
+// findScheme returns the first challenge using one of the given schemes
+func findScheme(challenges []challenge, schemes []string) (challenge, bool) {
+	for _, c := range challenges {
+		for _, scheme := range schemes {
+			if strings.EqualFold(c.Scheme, scheme) {
+				return c, true
+			}
+		}
+	}
+	return challenge{}, false
+}
+
+// challengeSchemes lists the schemes offered, in the order they were received
+func challengeSchemes(challenges []challenge) string {
+	schemes := make([]string, 0, len(challenges))
+	for _, c := range challenges {
+		schemes = append(schemes, c.Scheme)
+	}
+	return strings.Join(schemes, ", ")
+}
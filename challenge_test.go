@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseChallenges(t *testing.T) {
+	testCases := []struct {
+		headers    []string
+		challenges []challenge
+	}{
+		{
+			headers: []string{`Basic realm="Restricted Area"`},
+			challenges: []challenge{
+				{Scheme: "Basic", Realm: "Restricted Area", Params: map[string]string{"realm": "Restricted Area"}},
+			},
+		},
+		{
+			headers: []string{`Basic realm="simple", charset="UTF-8"`},
+			challenges: []challenge{
+				{Scheme: "Basic", Realm: "simple", Charset: "UTF-8", Params: map[string]string{"realm": "simple", "charset": "UTF-8"}},
+			},
+		},
+		{
+			headers: []string{`Digest realm="test, with comma", qop="auth,auth-int", nonce=abc123, Basic realm="fallback"`},
+			challenges: []challenge{
+				{Scheme: "Digest", Realm: "test, with comma", Params: map[string]string{"realm": "test, with comma", "qop": "auth,auth-int", "nonce": "abc123"}},
+				{Scheme: "Basic", Realm: "fallback", Params: map[string]string{"realm": "fallback"}},
+			},
+		},
+		{
+			headers: []string{"Negotiate", "NTLM", `Bearer realm="api", error="invalid_token"`},
+			challenges: []challenge{
+				{Scheme: "Negotiate", Params: map[string]string{}},
+				{Scheme: "NTLM", Params: map[string]string{}},
+				{Scheme: "Bearer", Realm: "api", Params: map[string]string{"realm": "api", "error": "invalid_token"}},
+			},
+		},
+		{
+			headers: []string{`Negotiate YIIBhgYGKwYBBQUC==, Basic realm="quoted \"realm\""`},
+			challenges: []challenge{
+				{Scheme: "Negotiate", Token: "YIIBhgYGKwYBBQUC==", Params: map[string]string{}},
+				{Scheme: "Basic", Realm: `quoted "realm"`, Params: map[string]string{"realm": `quoted "realm"`}},
+			},
+		},
+		{
+			headers:    []string{"", " , "},
+			challenges: nil,
+		},
+	}
+	for _, tc := range testCases {
+		got := parseChallenges(tc.headers)
+		if !reflect.DeepEqual(got, tc.challenges) {
+			t.Errorf("Incorrect challenges for %q:\n got: %+v\nwant: %+v", tc.headers, got, tc.challenges)
+		}
+	}
+}
+
+func TestFindScheme(t *testing.T) {
+	challenges := parseChallenges([]string{`Digest realm="a", nonce="x", basic realm="b"`})
+	c, ok := findScheme(challenges, []string{schemeBasic})
+	if !ok || c.Realm != "b" {
+		t.Errorf("Expected case insensitive match on basic challenge, got %+v", c)
+	}
+	if _, ok := findScheme(challenges, []string{schemeBearer}); ok {
+		t.Error("Found bearer challenge that was never offered")
+	}
+	if got := challengeSchemes(challenges); got != "Digest, basic" {
+		t.Errorf("Incorrect scheme list %q", got)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	idleConnTimeout            = 90 * time.Second
+)
+
+// duration is a time.Duration that can be decoded from TOML strings like "5s"
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalText(text []byte) (err error) {
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// newSiteClient builds the HTTP client used for all requests to a site,
+// applying the site's timeouts to the transport and its redirect policy
+func newSiteClient(site *site) *http.Client {
+	tlsHandshakeTimeout := site.TLSHandshakeTimeout.Duration
+	if tlsHandshakeTimeout == 0 {
+		tlsHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   site.ConnectTimeout.Duration,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       site.tlsConfig,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: site.ResponseHeaderTimeout.Duration,
+		MaxIdleConnsPerHost:   4,
+		IdleConnTimeout:       idleConnTimeout,
+	}
+	return &http.Client{
+		Transport:     transport,
+		Timeout:       site.Timeout.Duration,
+		CheckRedirect: checkRedirect(site),
+	}
+}
+
+// closeIdleConnections closes the kept-alive connections of the clients of
+// the sites, which are not used again once the sites are checked
+func closeIdleConnections(sites []site) {
+	for _, site := range sites {
+		for _, ep := range site.endpoints {
+			if ep.client != nil {
+				ep.client.CloseIdleConnections()
+			}
+		}
+	}
+}
+
+// setDefaultTimeout sets the request timeout of all sites that have none
+func setDefaultTimeout(sites []site, timeout time.Duration) {
+	for i := range sites {
+		if sites[i].Timeout.Duration == 0 {
+			sites[i].Timeout.Duration = timeout
+		}
+	}
+}
+
+// siteLimiter throttles the checks of a single site. slots limits the number
+// of endpoints checked at the same time, interval spaces out the requests.
+type siteLimiter struct {
+	slots    chan struct{}
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// newSiteLimiter returns nil if the site is not throttled at all. A rate
+// limited site checks one endpoint at a time unless max_concurrent is set, so
+// the workers waiting for its next request don't hold up the other sites.
+func newSiteLimiter(site *site) *siteLimiter {
+	if site.MaxConcurrent <= 0 && site.RequestsPerSecond <= 0 {
+		return nil
+	}
+	limiter := &siteLimiter{}
+	maxConcurrent := site.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	limiter.slots = make(chan struct{}, maxConcurrent)
+	if site.RequestsPerSecond > 0 {
+		limiter.interval = time.Duration(float64(time.Second) / site.RequestsPerSecond)
+	}
+	return limiter
+}
+
+// acquire blocks until another endpoint of the site may be checked
+func (l *siteLimiter) acquire(ctx context.Context) error {
+	if l == nil || l.slots == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *siteLimiter) release() {
+	if l == nil || l.slots == nil {
+		return
+	}
+	<-l.slots
+}
+
+// wait blocks until the next request to the site is allowed
+func (l *siteLimiter) wait(ctx context.Context) error {
+	if l == nil || l.interval == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withoutRedirects returns a copy of the client that returns redirects
+// instead of following them
+func withoutRedirects(client *http.Client) *http.Client {
+	if client == nil {
+		client = &http.Client{}
+	}
+	c := *client
+	c.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &c
+}
+
+// errPlainRedirect stops a redirect that would carry credentials without TLS
+var errPlainRedirect = errors.New("redirect to plain HTTP")
+
+// withoutPlainRedirects returns a copy of the client that refuses to follow
+// redirects to anything but https:// URLs. Go keeps the Authorization header
+// on redirects to the same host, even from https to http.
+func withoutPlainRedirects(client *http.Client) *http.Client {
+	if client == nil {
+		client = &http.Client{}
+	}
+	c := *client
+	checkRedirect := client.CheckRedirect
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if checkRedirect != nil {
+			if err := checkRedirect(req, via); err != nil {
+				return err
+			}
+		} else if len(via) >= defaultMaxRedirects {
+			return fmt.Errorf("stopped after %d redirects", defaultMaxRedirects)
+		}
+		if req.URL.Scheme != "https" {
+			return errPlainRedirect
+		}
+		return nil
+	}
+	return &c
+}
+
+// doRequest sends a request for the endpoint, respecting the site's rate limit
+func doRequest(ctx context.Context, ep *endpoint, req *http.Request) (*http.Response, error) {
+	if err := ep.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	client := ep.client
+	if client == nil {
+		client = &http.Client{}
+	}
+	return client.Do(req)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestSiteTimeoutConfig(t *testing.T) {
+	var config configuration
+	_, err := toml.Decode(`
+[[site]]
+base = "https://example.com"
+connect_timeout = "2s"
+tls_handshake_timeout = "3s"
+response_header_timeout = "1m"
+
+[[site]]
+base = "https://other.example.com"
+timeout = "20s"
+`, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setDefaultTimeout(config.Sites, 5*time.Second)
+	first, second := config.Sites[0], config.Sites[1]
+	if first.ConnectTimeout.Duration != 2*time.Second || first.TLSHandshakeTimeout.Duration != 3*time.Second ||
+		first.ResponseHeaderTimeout.Duration != time.Minute {
+		t.Errorf("Incorrect timeouts %+v", first)
+	}
+	if first.Timeout.Duration != 5*time.Second || second.Timeout.Duration != 20*time.Second {
+		t.Errorf("Incorrect request timeouts %s and %s", first.Timeout, second.Timeout)
+	}
+
+	client := newSiteClient(&first)
+	transport := client.Transport.(*http.Transport)
+	if client.Timeout != 5*time.Second || transport.TLSHandshakeTimeout != 3*time.Second ||
+		transport.ResponseHeaderTimeout != time.Minute {
+		t.Errorf("Timeouts not applied to client %+v", client)
+	}
+
+	if _, err := toml.Decode("[[site]]\ntimeout = \"soon\"", &config); err == nil {
+		t.Error("Expected error for invalid duration")
+	}
+}
+
+func TestCheckURLResponseHeaderTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+	ep := endpoint{
+		URL:    ts.URL,
+		client: newSiteClient(&site{ResponseHeaderTimeout: duration{20 * time.Millisecond}}),
+	}
+	checkURL(context.Background(), &ep)
+	if !ep.Error || ep.ErrorCategory != errorTimeout {
+		t.Errorf("Expected timeout error, got error=%t category=%q message=%q", ep.Error, ep.ErrorCategory, ep.ErrorMessage)
+	}
+}
+
+func TestCheckSitesDeadline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(time.Second)
+		}
+	}))
+	defer ts.Close()
+	sites := []site{site{Base: ts.URL, NoBasicAuth: []string{"fast", "slow"}}}
+	if err := populateURLConfig(sites); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	checkSites(ctx, sites, 30)
+	if elapsed := time.Since(started); elapsed > 800*time.Millisecond {
+		t.Errorf("Run took %s, longer than the deadline", elapsed)
+	}
+	for _, ep := range sites[0].endpoints {
+		switch ep.Path {
+		case "fast":
+			if !ep.Success {
+				t.Errorf("Expected finished endpoint to be kept, got %+v", ep)
+			}
+		case "slow":
+			if !ep.Error || ep.ErrorCategory != errorTimeout {
+				t.Errorf("Expected timeout, got error=%t category=%q", ep.Error, ep.ErrorCategory)
+			}
+		}
+	}
+}
+
+func TestCheckSitesThrottling(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		inFlight    = map[string]int{}
+		maxInFlight = map[string]int{}
+	)
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			inFlight[name]++
+			if inFlight[name] > maxInFlight[name] {
+				maxInFlight[name] = inFlight[name]
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			inFlight[name]--
+			mu.Unlock()
+		}
+	}
+	fragile := httptest.NewServer(handler("fragile"))
+	defer fragile.Close()
+	sturdy := httptest.NewServer(handler("sturdy"))
+	defer sturdy.Close()
+
+	paths := []string{"a", "b", "c", "d", "e", "f"}
+	sites := []site{
+		site{Base: fragile.URL, NoBasicAuth: paths, MaxConcurrent: 2},
+		site{Base: sturdy.URL, NoBasicAuth: paths},
+	}
+	if err := populateURLConfig(sites); err != nil {
+		t.Fatal(err)
+	}
+	checkSites(context.Background(), sites, 10)
+	if maxInFlight["fragile"] > 2 {
+		t.Errorf("Fragile site had %d concurrent requests, wanted at most 2", maxInFlight["fragile"])
+	}
+	if maxInFlight["sturdy"] < 3 {
+		t.Errorf("Sturdy site had only %d concurrent requests, throttled by the fragile site", maxInFlight["sturdy"])
+	}
+	for _, site := range sites {
+		for _, ep := range site.endpoints {
+			if !ep.Success {
+				t.Errorf("Expected success for %s, got %+v", ep.URL, ep)
+			}
+		}
+	}
+}
+
+func TestCheckSitesRateLimitIndependence(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		fastSeen time.Time
+	)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fastSeen = time.Now()
+		mu.Unlock()
+	}))
+	defer fast.Close()
+
+	paths := make([]string, 40)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("p%d", i)
+	}
+	sites := []site{
+		site{Base: fast.URL, NoBasicAuth: []string{"a"}},
+		site{Base: slow.URL, NoBasicAuth: paths, RequestsPerSecond: 20},
+	}
+	if err := populateURLConfig(sites); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	checkSites(context.Background(), sites, 2)
+	if elapsed := time.Since(started); elapsed < 1900*time.Millisecond {
+		t.Errorf("Forty requests at 20/s took only %s", elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if waited := fastSeen.Sub(started); waited > 500*time.Millisecond {
+		t.Errorf("Unthrottled site was checked after %s, held up by the rate limited site", waited)
+	}
+}
+
+func TestCheckSitesRateLimitSpacing(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen []time.Time
+	)
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, time.Now())
+		mu.Unlock()
+	}))
+	defer limited.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	sites := []site{
+		site{Base: limited.URL, NoBasicAuth: []string{"a", "b", "c"}, RequestsPerSecond: 10},
+		site{Base: slow.URL, NoBasicAuth: []string{"a"}},
+	}
+	if err := populateURLConfig(sites); err != nil {
+		t.Fatal(err)
+	}
+	checkSites(context.Background(), sites, 1)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 3 {
+		t.Fatalf("Expected 3 requests to the limited site, got %d", len(seen))
+	}
+	for i := 1; i < len(seen); i++ {
+		if gap := seen[i].Sub(seen[i-1]); gap < 90*time.Millisecond {
+			t.Errorf("Requests %d and %d to the limited site were only %s apart", i, i+1, gap)
+		}
+	}
+}
+
+func TestSiteLimiterRate(t *testing.T) {
+	limiter := newSiteLimiter(&site{RequestsPerSecond: 50})
+	started := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first request goes right away, the next five are 20ms apart
+	if elapsed := time.Since(started); elapsed < 100*time.Millisecond {
+		t.Errorf("Six requests at 50/s took only %s", elapsed)
+	}
+	if newSiteLimiter(&site{}) != nil {
+		t.Error("Expected no limiter for an unthrottled site")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// credentials are used to prove that a protected endpoint can actually be
+// unlocked. The password is never given inline, only through an environment
+// variable or a file.
+type credentials struct {
+	Username     string `toml:"username"`
+	Password     string `toml:"password"`
+	PasswordEnv  string `toml:"password_env"`
+	PasswordFile string `toml:"password_file"`
+	ExpectStatus int    `toml:"expect_status"`
+	password     string
+}
+
+// loadCredentials validates the credentials config and resolves the password
+func loadCredentials(c *credentials) error {
+	switch {
+	case c.Username == "":
+		return errors.New("credentials need a username")
+	case c.Password != "":
+		return fmt.Errorf("inline password for %s is not allowed, use password_env or password_file", c.Username)
+	case c.PasswordEnv != "" && c.PasswordFile != "":
+		return fmt.Errorf("credentials for %s have both password_env and password_file", c.Username)
+	case c.PasswordEnv != "":
+		password, ok := os.LookupEnv(c.PasswordEnv)
+		if !ok {
+			return fmt.Errorf("environment variable %s for %s is not set", c.PasswordEnv, c.Username)
+		}
+		c.password = password
+	case c.PasswordFile != "":
+		content, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("reading password file for %s: %s", c.Username, err)
+		}
+		c.password = strings.TrimRight(string(content), "\r\n")
+	default:
+		return fmt.Errorf("credentials for %s need password_env or password_file", c.Username)
+	}
+	if c.ExpectStatus == 0 {
+		c.ExpectStatus = http.StatusOK
+	}
+	return nil
+}
+
+// insecureCredentialsReason tells why the credentials of the endpoint would
+// be exposed, or "" if they are safe to send or the site allows it anyway
+func insecureCredentialsReason(ep *endpoint) string {
+	if ep.allowInsecureCredentials {
+		return ""
+	}
+	if isPlainHTTP(ep.URL) {
+		return "plain HTTP"
+	}
+	if ep.client != nil {
+		if transport, ok := ep.client.Transport.(*http.Transport); ok &&
+			transport.TLSClientConfig != nil && transport.TLSClientConfig.InsecureSkipVerify {
+			return "insecure_skip_verify"
+		}
+	}
+	return ""
+}
+
+// checkCredentials repeats the request with the endpoint's credentials and
+// checks that it is answered with the expected status. Credentials are never
+// sent where anyone in between could read them, unless the site allows it.
+func checkCredentials(ctx context.Context, ep *endpoint) {
+	if reason := insecureCredentialsReason(ep); reason != "" {
+		ep.CredentialsSkipped = true
+		ep.CredentialsStatus = fmt.Sprintf("skipped (%s)", reason)
+		return
+	}
+	ep.CredentialsChecked = true
+	req, err := newRequest(ctx, ep)
+	if err != nil {
+		ep.CredentialsStatus = err.Error()
+		return
+	}
+	req.SetBasicAuth(ep.credentials.Username, ep.credentials.password)
+	secure := *ep
+	if !ep.allowInsecureCredentials {
+		secure.client = withoutPlainRedirects(ep.client)
+	}
+	response, err := doRequest(ctx, &secure, req)
+	if errors.Is(err, errPlainRedirect) {
+		ep.CredentialsStatus = errPlainRedirect.Error()
+		return
+	}
+	if err != nil {
+		ep.CredentialsStatus = fmt.Sprintf("%s error", classifyError(err))
+		return
+	}
+	response.Body.Close()
+	ep.CredentialsStatus = response.Status
+	ep.CredentialsOK = response.StatusCode == ep.credentials.ExpectStatus
+}
+
+// randomString returns a random hex string, used for invalid credentials
+func randomString() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		// Extremely unlikely, but never fall back to something guessable
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// checkInvalidCredentials sends random Basic credentials, which a correctly
+// configured endpoint must still answer with 401 or 403
+func checkInvalidCredentials(ctx context.Context, ep *endpoint) {
+	req, err := newRequest(ctx, ep)
+	if err != nil {
+		ep.InvalidCredentialsError = true
+		ep.InvalidCredentialsStatus = err.Error()
+		return
+	}
+	req.SetBasicAuth("ba_checker-"+randomString(), randomString())
+	response, err := doRequest(ctx, ep, req)
+	if err != nil {
+		ep.InvalidCredentialsError = true
+		ep.InvalidCredentialsStatus = fmt.Sprintf("%s error", classifyError(err))
+		return
+	}
+	response.Body.Close()
+	ep.InvalidCredentialsChecked = true
+	ep.InvalidCredentialsStatus = response.Status
+	ep.AcceptsArbitraryCredentials = response.StatusCode != http.StatusUnauthorized &&
+		response.StatusCode != http.StatusForbidden
+}
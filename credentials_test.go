@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLoadCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ba_checker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(passwordFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("BA_CHECKER_TEST_PASSWORD", "from-env")
+	defer os.Unsetenv("BA_CHECKER_TEST_PASSWORD")
+
+	testCases := []struct {
+		creds    credentials
+		password string
+		valid    bool
+	}{
+		{credentials{Username: "svc", PasswordEnv: "BA_CHECKER_TEST_PASSWORD"}, "from-env", true},
+		{credentials{Username: "svc", PasswordFile: passwordFile}, "from-file", true},
+		{credentials{Username: "svc", Password: "inline"}, "", false},
+		{credentials{Username: "svc", PasswordEnv: "BA_CHECKER_TEST_UNSET"}, "", false},
+		{credentials{Username: "svc", PasswordFile: filepath.Join(dir, "missing")}, "", false},
+		{credentials{Username: "svc"}, "", false},
+		{credentials{PasswordEnv: "BA_CHECKER_TEST_PASSWORD"}, "", false},
+	}
+	for _, tc := range testCases {
+		err := loadCredentials(&tc.creds)
+		if (err == nil) != tc.valid {
+			t.Errorf("Credentials %+v: got error %v, wanted valid=%t", tc.creds, err, tc.valid)
+			continue
+		}
+		if tc.valid && tc.creds.password != tc.password {
+			t.Errorf("Incorrect password %q, wanted %q", tc.creds.password, tc.password)
+		}
+		if tc.valid && tc.creds.ExpectStatus != http.StatusOK {
+			t.Errorf("Incorrect default expected status %d", tc.creds.ExpectStatus)
+		}
+	}
+}
+
+func TestCheckURLCredentials(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if ok && user == "svc" && password == "secret" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	testCases := []struct {
+		creds   credentials
+		success bool
+	}{
+		{credentials{Username: "svc", password: "secret", ExpectStatus: http.StatusNoContent}, true},
+		{credentials{Username: "svc", password: "secret", ExpectStatus: http.StatusOK}, false},
+		{credentials{Username: "svc", password: "wrong", ExpectStatus: http.StatusNoContent}, false},
+	}
+	for _, tc := range testCases {
+		creds := tc.creds
+		// The test server is plain HTTP
+		ep := endpoint{URL: ts.URL, BaShouldBe: true, credentials: &creds, allowInsecureCredentials: true}
+		checkURL(context.Background(), &ep)
+		if !ep.CredentialsChecked {
+			t.Fatal("Expected credentials to be checked")
+		}
+		if ep.Success != tc.success || ep.CredentialsOK != tc.success {
+			t.Errorf("Password %q expecting %d: got success=%t credentialsOK=%t (%s), wanted %t",
+				creds.password, creds.ExpectStatus, ep.Success, ep.CredentialsOK, ep.CredentialsStatus, tc.success)
+		}
+	}
+}
+
+func TestCheckCredentialsInsecure(t *testing.T) {
+	sent := false
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok {
+			sent = true
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+
+	testCases := []struct {
+		site   site
+		reason string
+	}{
+		{site{Base: plain.URL}, "plain HTTP"},
+		{site{Base: secure.URL, TLS: &tlsOptions{InsecureSkipVerify: true}}, "insecure_skip_verify"},
+	}
+	for _, tc := range testCases {
+		sites := []site{tc.site}
+		sites[0].BasicAuth = []string{"admin"}
+		sites[0].PlainHTTPSeverity = severityWarning
+		if err := populateURLConfig(sites); err != nil {
+			t.Fatal(err)
+		}
+		ep := &sites[0].endpoints[0]
+		ep.credentials = &credentials{Username: "svc", password: "secret", ExpectStatus: http.StatusOK}
+		sent = false
+		checkURL(context.Background(), ep)
+		if sent || ep.CredentialsChecked || !ep.CredentialsSkipped || !ep.Success {
+			t.Errorf("Credentials sent over %s: sent %t, checked %t, skipped %t, success %t",
+				tc.reason, sent, ep.CredentialsChecked, ep.CredentialsSkipped, ep.Success)
+		}
+		if want := "skipped (" + tc.reason + ")"; credentialsMessage(*ep) != want {
+			t.Errorf("Incorrect credentials message %q, wanted %q", credentialsMessage(*ep), want)
+		}
+
+		sites[0].AllowInsecureCredentials = true
+		sites[0].endpoints = nil
+		if err := populateURLConfig(sites); err != nil {
+			t.Fatal(err)
+		}
+		ep = &sites[0].endpoints[0]
+		ep.credentials = &credentials{Username: "svc", password: "secret", ExpectStatus: http.StatusOK}
+		checkURL(context.Background(), ep)
+		if !sent || !ep.CredentialsChecked {
+			t.Errorf("Credentials not sent over %s although the site allows it", tc.reason)
+		}
+	}
+}
+
+func TestCheckCredentialsPlainRedirect(t *testing.T) {
+	var leaked atomic.Bool
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok {
+			leaked.Store(true)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok {
+			http.Redirect(w, r, plain.URL+"/after", http.StatusFound)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer secure.Close()
+
+	ep := endpoint{URL: secure.URL + "/admin", BaShouldBe: true, client: secure.Client(),
+		credentials: &credentials{Username: "svc", password: "s3cret", ExpectStatus: http.StatusOK}}
+	checkURL(context.Background(), &ep)
+	if leaked.Load() {
+		t.Error("Credentials were sent to the plain HTTP redirect target")
+	}
+	if !ep.CredentialsChecked || ep.CredentialsOK || ep.Success {
+		t.Errorf("Expected the credentials to be rejected, got checked=%t ok=%t success=%t",
+			ep.CredentialsChecked, ep.CredentialsOK, ep.Success)
+	}
+	if got := credentialsMessage(ep); got != "rejected (redirect to plain HTTP)" {
+		t.Errorf("Incorrect credentials message %q", got)
+	}
+}
+
+func TestCheckURLInvalidCredentials(t *testing.T) {
+	var acceptAny, hangUp atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok && hangUp.Load() {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		if _, _, ok := r.BasicAuth(); ok && acceptAny.Load() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	ep := endpoint{URL: ts.URL, BaShouldBe: true, checkInvalid: true}
+	checkURL(context.Background(), &ep)
+	if !ep.InvalidCredentialsChecked || ep.AcceptsArbitraryCredentials {
+		t.Errorf("Expected invalid credentials to be rejected, got checked=%t accepted=%t",
+			ep.InvalidCredentialsChecked, ep.AcceptsArbitraryCredentials)
+	}
+
+	hangUp.Store(true)
+	ep = endpoint{URL: ts.URL, BaShouldBe: true, checkInvalid: true}
+	checkURL(context.Background(), &ep)
+	if !ep.InvalidCredentialsError || ep.InvalidCredentialsStatus != "protocol error" || ep.AcceptsArbitraryCredentials {
+		t.Errorf("Expected the failed check to be recorded, got error=%t status=%q",
+			ep.InvalidCredentialsError, ep.InvalidCredentialsStatus)
+	}
+	if got := credentialsMessage(ep); got != "invalid credentials not checked (protocol error)" {
+		t.Errorf("Incorrect credentials message %q", got)
+	}
+	hangUp.Store(false)
+
+	acceptAny.Store(true)
+	// The test server is plain HTTP, which would be critical on its own
+	ep = endpoint{URL: ts.URL, BaShouldBe: true, checkInvalid: true, plainHTTPSeverity: severityWarning}
+	checkURL(context.Background(), &ep)
+	if !ep.AcceptsArbitraryCredentials {
+		t.Fatal("Expected endpoint to accept arbitrary credentials")
+	}
+	sites := []site{site{endpoints: []endpoint{ep}}}
+	if status := checkStatus(sites, countThreshold(1), countThreshold(2)); status != 1 {
+		t.Errorf("Incorrect status %d, wanted at least WARNING", status)
+	}
+	if status := checkStatus(sites, countThreshold(5), countThreshold(10)); status != 1 {
+		t.Errorf("Incorrect status %d with high thresholds, wanted WARNING", status)
+	}
+}
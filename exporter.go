@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// endpointMetric is a per-endpoint gauge exposed by the exporter
+type endpointMetric struct {
+	name  string
+	help  string
+	value func(ep endpoint) float64
+}
+
+var endpointMetrics = []endpointMetric{
+	{"ba_checker_endpoint_success", "Whether the endpoint is in its expected Basic Auth state (1) or not (0)",
+		func(ep endpoint) float64 { return boolToFloat(ep.Success) }},
+	{"ba_checker_endpoint_ba_enabled", "Whether the endpoint requires Basic Auth",
+		func(ep endpoint) float64 { return boolToFloat(ep.BaEnabled) }},
+	{"ba_checker_endpoint_ba_expected", "Whether the endpoint is expected to require Basic Auth",
+		func(ep endpoint) float64 { return boolToFloat(ep.BaShouldBe) }},
+	{"ba_checker_endpoint_error", "Whether the endpoint could not be checked because of a connection error",
+		func(ep endpoint) float64 { return boolToFloat(ep.Error) }},
+	{"ba_checker_endpoint_http_status_code", "HTTP status code of the response, 0 if there was none",
+		func(ep endpoint) float64 { return float64(ep.HTTPStatusCode) }},
+	{"ba_checker_endpoint_request_duration_seconds", "Duration of the request",
+		func(ep endpoint) float64 { return ep.Duration.Seconds() }},
+	{"ba_checker_endpoint_cert_expiry_timestamp_seconds", "Unix time the certificate of the endpoint expires, 0 without TLS",
+		func(ep endpoint) float64 {
+			if ep.CertExpiry.IsZero() {
+				return 0
+			}
+			return float64(ep.CertExpiry.Unix())
+		}},
+	{"ba_checker_endpoint_findings", "Number of weaknesses in the protection found by audits and policy checks",
+		func(ep endpoint) float64 { return float64(len(ep.Findings)) }},
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders Prometheus labels from name/value pairs
+func formatLabels(pairs ...string) string {
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, pairs[i], labelValueEscaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func writeMetricHeader(w io.Writer, name string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+// writeMetric writes a single gauge with the given labels
+func writeMetric(w io.Writer, metric endpointMetric, labels string, ep endpoint) {
+	fmt.Fprintf(w, "%s%s %g\n", metric.name, labels, metric.value(ep))
+}
+
+// writeEndpointMetrics writes all endpoint gauges in the Prometheus text
+// format, labelled by site base, path and method, as endpoint tables can
+// check the same path with several methods
+func writeEndpointMetrics(w io.Writer, sites []site) {
+	for _, metric := range endpointMetrics {
+		writeMetricHeader(w, metric.name, metric.help)
+		for _, site := range sites {
+			for _, ep := range site.endpoints {
+				method := ep.Method
+				if method == "" {
+					method = "GET"
+				}
+				writeMetric(w, metric, formatLabels("site", site.Base, "path", ep.Path, "method", method), ep)
+			}
+		}
+	}
+}
+
+// exporter runs the checks on an interval and serves the latest results
+type exporter struct {
+	sync.RWMutex
+	config       configuration
+	interval     time.Duration
+	concurrency  int
+	sites        []site
+	lastRun      time.Time
+	lastDuration time.Duration
+}
+
+// newSites returns a copy of the configured sites with fresh endpoints
+func newSites(config configuration) ([]site, error) {
+	sites := make([]site, len(config.Sites))
+	copy(sites, config.Sites)
+	for i := range sites {
+		sites[i].endpoints = nil
+	}
+	return sites, populateURLConfig(sites)
+}
+
+func (e *exporter) run() error {
+	sites, err := newSites(e.config)
+	if err != nil {
+		return err
+	}
+	// A run must be done before the next one is due
+	ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+	defer cancel()
+	started := time.Now()
+	checkSites(ctx, sites, e.concurrency)
+	// Every run builds new clients, the connections of this one are done
+	closeIdleConnections(sites)
+	e.Lock()
+	defer e.Unlock()
+	e.sites = sites
+	e.lastRun = started
+	e.lastDuration = time.Since(started)
+	return nil
+}
+
+func (e *exporter) loop() {
+	for {
+		if err := e.run(); err != nil {
+			fmt.Println("Error:", err)
+		}
+		time.Sleep(e.interval)
+	}
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.RLock()
+	defer e.RUnlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeEndpointMetrics(w, e.sites)
+	if e.lastRun.IsZero() {
+		return
+	}
+	writeMetricHeader(w, "ba_checker_last_run_timestamp_seconds", "Unix time of the start of the last check run")
+	fmt.Fprintf(w, "ba_checker_last_run_timestamp_seconds %d\n", e.lastRun.Unix())
+	writeMetricHeader(w, "ba_checker_last_run_duration_seconds", "Duration of the last check run")
+	fmt.Fprintf(w, "ba_checker_last_run_duration_seconds %g\n", e.lastDuration.Seconds())
+}
+
+// newProbeHandler returns a handler that checks a single target on demand,
+// like blackbox_exporter. Prometheus passes the target and the expectation
+// as query parameters.
+func newProbeHandler(timeout time.Duration) http.HandlerFunc {
+	client := newSiteClient(&site{Timeout: duration{timeout}})
+	return func(w http.ResponseWriter, r *http.Request) {
+		probe(w, r, client)
+	}
+}
+
+func probe(w http.ResponseWriter, r *http.Request, client *http.Client) {
+	target := r.URL.Query().Get("target")
+	targetURL, err := url.Parse(target)
+	if target == "" || err != nil || (targetURL.Scheme != "http" && targetURL.Scheme != "https") {
+		http.Error(w, fmt.Sprintf("Invalid target %q, an http or https URL is required", target), http.StatusBadRequest)
+		return
+	}
+	ep := endpoint{URL: target, Path: targetURL.Path, client: client}
+	switch expect := r.URL.Query().Get("expect"); expect {
+	case "", "auth":
+		ep.BaShouldBe = true
+	case "noauth":
+		ep.BaShouldBe = false
+	default:
+		http.Error(w, fmt.Sprintf("Invalid expect %q, use auth or noauth", expect), http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	if seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64); err == nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(seconds*float64(time.Second)))
+		defer cancel()
+	}
+	checkURL(ctx, &ep)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, metric := range endpointMetrics {
+		writeMetricHeader(w, metric.name, metric.help)
+		writeMetric(w, metric, "", ep)
+	}
+}
+
+// serve exposes /probe, and /metrics for the sites of the config if given
+func serve(config *configuration, listen string, interval time.Duration, timeout time.Duration, concurrency int) error {
+	mux := http.NewServeMux()
+	mux.Handle("/probe", newProbeHandler(timeout))
+	if config != nil {
+		e := &exporter{config: *config, interval: interval, concurrency: concurrency}
+		go e.loop()
+		mux.Handle("/metrics", e)
+		fmt.Printf("Serving metrics on %s/metrics, checking every %s\n", listen, interval)
+	}
+	fmt.Printf("Serving probes on %s/probe\n", listen)
+	return http.ListenAndServe(listen, mux)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFormatLabels(t *testing.T) {
+	got := formatLabels("site", "https://example.com", "path", `a"b\c`+"\n")
+	want := `{site="https://example.com",path="a\"b\\c\n"}`
+	if got != want {
+		t.Errorf("Incorrect labels %s, wanted %s", got, want)
+	}
+}
+
+func TestWriteEndpointMetrics(t *testing.T) {
+	sites := []site{
+		site{
+			Base: "https://example.com",
+			endpoints: []endpoint{
+				endpoint{Path: "admin", BaShouldBe: true, BaEnabled: true, Success: true, HTTPStatusCode: 401},
+				endpoint{Path: "api", Method: "GET", Success: true, HTTPStatusCode: 200},
+				endpoint{Path: "api", Method: "POST", BaShouldBe: true, HTTPStatusCode: 200},
+			},
+		},
+	}
+	var b bytes.Buffer
+	writeEndpointMetrics(&b, sites)
+	for _, want := range []string{
+		"# TYPE ba_checker_endpoint_success gauge\n",
+		`ba_checker_endpoint_success{site="https://example.com",path="admin",method="GET"} 1` + "\n",
+		`ba_checker_endpoint_ba_expected{site="https://example.com",path="admin",method="GET"} 1` + "\n",
+		`ba_checker_endpoint_http_status_code{site="https://example.com",path="admin",method="GET"} 401` + "\n",
+		`ba_checker_endpoint_error{site="https://example.com",path="admin",method="GET"} 0` + "\n",
+		`ba_checker_endpoint_success{site="https://example.com",path="api",method="GET"} 1` + "\n",
+		`ba_checker_endpoint_success{site="https://example.com",path="api",method="POST"} 0` + "\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Missing %q in:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestExporter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/admin" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+	config := configuration{
+		Sites: []site{
+			site{Base: ts.URL, BasicAuth: []string{"admin"}, NoBasicAuth: []string{""}},
+		},
+	}
+	e := &exporter{config: config, interval: time.Minute, concurrency: 2}
+	for i := 0; i < 2; i++ {
+		// Running twice must not accumulate endpoints
+		if err := e.run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := numberOfTotalURLs(e.sites); got != 2 {
+		t.Errorf("Incorrect number of endpoints %d, wanted 2", got)
+	}
+	if len(config.Sites[0].endpoints) != 0 {
+		t.Error("Exporter run modified the configured sites")
+	}
+
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`ba_checker_endpoint_success{site="` + ts.URL + `",path="admin",method="GET"} 1`,
+		`ba_checker_endpoint_ba_enabled{site="` + ts.URL + `",path="admin",method="GET"} 1`,
+		`ba_checker_endpoint_success{site="` + ts.URL + `",path="",method="GET"} 1`,
+		"ba_checker_last_run_timestamp_seconds ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Missing %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestExporterClosesIdleConnections(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		conns = map[net.Conn]bool{}
+	)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+		if state == http.StateClosed || state == http.StateHijacked {
+			delete(conns, conn)
+		} else {
+			conns[conn] = true
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+	config := configuration{
+		Sites: []site{
+			site{Base: ts.URL, NoBasicAuth: []string{"a", "b", "c", "d"}},
+		},
+	}
+	e := &exporter{config: config, interval: time.Minute, concurrency: 4}
+	for i := 0; i < 10; i++ {
+		if err := e.run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	open := 0
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		open = len(conns)
+		mu.Unlock()
+		if open == 0 {
+			return
+		}
+	}
+	t.Errorf("%d connections still open after the runs", open)
+}
+
+func TestProbeHandler(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	testCases := []struct {
+		query   string
+		code    int
+		success string
+	}{
+		{"?target=" + ts.URL + "/admin&expect=auth", http.StatusOK, "ba_checker_endpoint_success 1\n"},
+		{"?target=" + ts.URL + "/admin", http.StatusOK, "ba_checker_endpoint_success 1\n"},
+		{"?target=" + ts.URL + "/admin&expect=noauth", http.StatusOK, "ba_checker_endpoint_success 0\n"},
+		{"?target=" + ts.URL + "&expect=maybe", http.StatusBadRequest, ""},
+		{"?target=ftp://example.com/", http.StatusBadRequest, ""},
+		{"", http.StatusBadRequest, ""},
+	}
+	for _, tc := range testCases {
+		recorder := httptest.NewRecorder()
+		newProbeHandler(time.Second)(recorder, httptest.NewRequest("GET", "/probe"+tc.query, nil))
+		if recorder.Code != tc.code {
+			t.Errorf("Probe %s: incorrect status %d, wanted %d", tc.query, recorder.Code, tc.code)
+			continue
+		}
+		body := recorder.Body.String()
+		if tc.success != "" && !strings.Contains(body, tc.success) {
+			t.Errorf("Probe %s: missing %q in:\n%s", tc.query, tc.success, body)
+		}
+		if tc.code == http.StatusOK && !strings.Contains(body, "ba_checker_endpoint_http_status_code 401\n") {
+			t.Errorf("Probe %s: missing status code in:\n%s", tc.query, body)
+		}
+	}
+}
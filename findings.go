@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+const (
+	severityWarning  = "warning"
+	severityCritical = "critical"
+)
+
+// severityStatus maps the severity of a finding to the status it raises the
+// run to at least
+var severityStatus = map[string]int{
+	severityWarning:  1,
+	severityCritical: 2,
+}
+
+// finding is a weakness in the protection of an endpoint, found by an audit
+// or a policy check
+type finding struct {
+	Check      string            `json:"check"`
+	Severity   string            `json:"severity"`
+	Method     string            `json:"method"`
+	URL        string            `json:"url"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	StatusCode int               `json:"status_code"`
+	Status     string            `json:"status"`
+}
+
+// headersMessage lists the headers of a finding, sorted by name
+func headersMessage(headers map[string]string) string {
+	lines := make([]string, 0, len(headers))
+	for name, value := range headers {
+		lines = append(lines, fmt.Sprintf("%s: %s", name, value))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func getTotalFindings(sites []site) (findings int) {
+	for _, site := range sites {
+		for _, ep := range site.endpoints {
+			findings += len(ep.Findings)
+		}
+	}
+	return findings
+}
+
+// findingsStatus returns the status the findings raise the run to
+func findingsStatus(sites []site) (status int) {
+	for _, site := range sites {
+		for _, ep := range site.endpoints {
+			for _, f := range ep.Findings {
+				status = worseStatus(status, severityStatus[f.Severity])
+			}
+		}
+	}
+	return status
+}
+
+// printFindingsTable lists the findings below the results table
+func printFindingsTable(sites []site) {
+	if getTotalFindings(sites) == 0 {
+		return
+	}
+	fmt.Println("\nFindings:")
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetBorders(tablewriter.Border{Left: false, Top: false, Right: false, Bottom: true})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader([]string{"Check", "Severity", "Method", "URL", "Headers", "HTTP Status"})
+	for _, site := range sites {
+		sort.Sort(endpointSorter(site.endpoints))
+		for _, ep := range site.endpoints {
+			for _, f := range ep.Findings {
+				table.Append([]string{f.Check, f.Severity, f.Method, f.URL, headersMessage(f.Headers), f.Status})
+			}
+		}
+	}
+	table.Render()
+}
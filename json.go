@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+)
+
+type jsonResult struct {
+	Version    string     `json:"version"`
+	Started    time.Time  `json:"started"`
+	Finished   time.Time  `json:"finished"`
+	Status     string     `json:"status"`
+	StatusCode int        `json:"status_code"`
+	Sites      []jsonSite `json:"sites"`
+}
+
+type jsonSite struct {
+	Base      string         `json:"base"`
+	Status    string         `json:"status"`
+	Endpoints []jsonEndpoint `json:"endpoints"`
+}
+
+type jsonEndpoint struct {
+	Method                      string     `json:"method"`
+	URL                         string     `json:"url"`
+	Success                     bool       `json:"success"`
+	ExpectedBA                  bool       `json:"expected_ba"`
+	ActualBA                    bool       `json:"actual_ba"`
+	Unknown                     bool       `json:"unknown"`
+	ExpectedScheme              string     `json:"expected_scheme"`
+	Scheme                      string     `json:"scheme"`
+	ExpectedRealm               string     `json:"expected_realm,omitempty"`
+	Realm                       string     `json:"realm,omitempty"`
+	ExpectedRedirect            string     `json:"expected_redirect,omitempty"`
+	Redirects                   []string   `json:"redirects,omitempty"`
+	ExpectedStatus              string     `json:"expected_status,omitempty"`
+	StatusCode                  int        `json:"status_code"`
+	Status                      string     `json:"status"`
+	LatencyMS                   float64    `json:"latency_ms"`
+	Attempts                    int        `json:"attempts"`
+	Retried                     bool       `json:"retried"`
+	TLSVersion                  string     `json:"tls_version,omitempty"`
+	CertExpiry                  *time.Time `json:"cert_expiry,omitempty"`
+	AssertionFailure            string     `json:"assertion_failure,omitempty"`
+	Credentials                 string     `json:"credentials,omitempty"`
+	AcceptsArbitraryCredentials bool       `json:"accepts_arbitrary_credentials,omitempty"`
+	InvalidCredentialsError     string     `json:"invalid_credentials_error,omitempty"`
+	Error                       *jsonError `json:"error,omitempty"`
+	Findings                    []finding  `json:"findings,omitempty"`
+}
+
+type jsonError struct {
+	Category string `json:"category"`
+	Message  string `json:"message"`
+}
+
+func newJSONEndpoint(ep endpoint) jsonEndpoint {
+	result := jsonEndpoint{
+		Method:                      ep.Method,
+		URL:                         ep.URL,
+		Success:                     ep.Success,
+		ExpectedBA:                  ep.BaShouldBe,
+		ActualBA:                    ep.BaEnabled,
+		Unknown:                     ep.Unknown,
+		ExpectedScheme:              wantedSchemeMessage(ep),
+		Scheme:                      ep.AuthScheme,
+		ExpectedRealm:               ep.ExpectRealm,
+		Realm:                       ep.Realm,
+		ExpectedRedirect:            ep.ExpectRedirect,
+		Redirects:                   ep.Redirects,
+		ExpectedStatus:              ep.expectStatus.String(),
+		StatusCode:                  ep.HTTPStatusCode,
+		Status:                      ep.HTTPStatus,
+		LatencyMS:                   ep.Duration.Seconds() * 1000,
+		Attempts:                    ep.Attempts,
+		Retried:                     ep.Attempts > 1,
+		TLSVersion:                  ep.TLSVersion,
+		AssertionFailure:            ep.AssertionFailure,
+		AcceptsArbitraryCredentials: ep.AcceptsArbitraryCredentials,
+		Findings:                    ep.Findings,
+	}
+	if !ep.CertExpiry.IsZero() {
+		result.CertExpiry = &ep.CertExpiry
+	}
+	if ep.CredentialsSkipped {
+		result.Credentials = "skipped"
+	}
+	if ep.CredentialsChecked {
+		result.Credentials = "rejected"
+		if ep.CredentialsOK {
+			result.Credentials = "ok"
+		}
+	}
+	if ep.InvalidCredentialsError {
+		result.InvalidCredentialsError = ep.InvalidCredentialsStatus
+	}
+	if ep.Error {
+		result.Error = &jsonError{Category: ep.ErrorCategory, Message: ep.ErrorMessage}
+	}
+	return result
+}
+
+func newJSONResult(sites []site, statusCode int, started time.Time, finished time.Time) jsonResult {
+	result := jsonResult{
+		Version:    toolVersion,
+		Started:    started,
+		Finished:   finished,
+		Status:     lookUpStatusCodeMap[statusCode],
+		StatusCode: statusCode,
+		Sites:      []jsonSite{},
+	}
+	for _, site := range sites {
+		sort.Sort(endpointSorter(site.endpoints))
+		js := jsonSite{Base: site.Base, Status: lookUpStatusCodeMap[site.status], Endpoints: []jsonEndpoint{}}
+		for _, ep := range site.endpoints {
+			js.Endpoints = append(js.Endpoints, newJSONEndpoint(ep))
+		}
+		result.Sites = append(result.Sites, js)
+	}
+	return result
+}
+
+func printJSONResult(sites []site, statusCode int, started time.Time, finished time.Time) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(newJSONResult(sites, statusCode, started, finished))
+}
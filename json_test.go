@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewJSONResult(t *testing.T) {
+	started := time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+	finished := started.Add(2 * time.Second)
+	sites := []site{
+		site{
+			Base: "https://example.com",
+			endpoints: []endpoint{
+				endpoint{
+					URL:                "https://example.com/admin",
+					BaShouldBe:         true,
+					BaEnabled:          true,
+					Success:            true,
+					AuthScheme:         "Basic",
+					Realm:              "Admin",
+					HTTPStatusCode:     401,
+					HTTPStatus:         "401 Unauthorized",
+					Duration:           1500 * time.Microsecond,
+					credentials:        &credentials{Username: "svc", password: "secret"},
+					CredentialsChecked: true,
+					CredentialsOK:      true,
+				},
+				endpoint{
+					URL:           "https://example.com/",
+					Error:         true,
+					ErrorCategory: errorDNS,
+					ErrorMessage:  "no such host",
+				},
+			},
+		},
+	}
+	result := newJSONResult(sites, 3, started, finished)
+	if result.Version != toolVersion || result.Status != "UNKNOWN" || result.StatusCode != 3 {
+		t.Errorf("Incorrect result header %+v", result)
+	}
+	if len(result.Sites) != 1 || len(result.Sites[0].Endpoints) != 2 {
+		t.Fatalf("Incorrect sites %+v", result.Sites)
+	}
+	errored, protected := result.Sites[0].Endpoints[0], result.Sites[0].Endpoints[1]
+	if errored.Error == nil || errored.Error.Category != errorDNS {
+		t.Errorf("Expected dns error, got %+v", errored.Error)
+	}
+	if !protected.ExpectedBA || !protected.ActualBA || protected.Scheme != "Basic" || protected.LatencyMS != 1.5 {
+		t.Errorf("Incorrect endpoint %+v", protected)
+	}
+	if protected.Credentials != "ok" {
+		t.Errorf("Incorrect credentials result %q", protected.Credentials)
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(encoded), "secret") {
+		t.Error("Password leaked into JSON output")
+	}
+	if !strings.Contains(string(encoded), `"started":"2016-10-01T12:00:00Z"`) {
+		t.Errorf("Missing start time in %s", encoded)
+	}
+}
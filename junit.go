@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+}
+
+// junitProblem is used for both <failure> and <error> elements
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Details string `xml:",cdata"`
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// junitDetails describes the endpoint result in the body of a failure
+func junitDetails(ep endpoint) string {
+	details := []string{
+		fmt.Sprintf("URL: %s", ep.URL),
+		fmt.Sprintf("Expected BA: %s (%s)", yesNo(ep.BaShouldBe), wantedSchemeMessage(ep)),
+		fmt.Sprintf("Actual BA: %s", yesNo(ep.BaEnabled)),
+	}
+	if ep.AuthScheme != "" {
+		details = append(details, fmt.Sprintf("Scheme: %s", ep.AuthScheme))
+	}
+	if ep.ExpectRealm != "" {
+		details = append(details, fmt.Sprintf("Realm: %q (wanted %q)", ep.Realm, ep.ExpectRealm))
+	}
+	if len(ep.Redirects) > 0 {
+		details = append(details, fmt.Sprintf("Redirects: %s", strings.Join(ep.Redirects, " -> ")))
+	}
+	if ep.HTTPStatus != "" {
+		details = append(details, fmt.Sprintf("HTTP status: %s", ep.HTTPStatus))
+	}
+	if message := tlsMessage(ep); message != "" {
+		details = append(details, fmt.Sprintf("TLS: %s", message))
+	}
+	if message := credentialsMessage(ep); message != "" {
+		details = append(details, fmt.Sprintf("Credentials: %s", message))
+	}
+	if ep.Error {
+		details = append(details, fmt.Sprintf("Error: %s: %s", ep.ErrorCategory, ep.ErrorMessage))
+	}
+	for _, f := range ep.Findings {
+		details = append(details, fmt.Sprintf("Finding: %s (%s), %s %s answered with HTTP %s", f.Check, f.Severity, f.Method, f.URL, f.Status))
+		if len(f.Headers) > 0 {
+			details = append(details, headersMessage(f.Headers))
+		}
+	}
+	return strings.Join(details, "\n")
+}
+
+func newJUnitTestCase(site site, ep endpoint) junitTestCase {
+	tc := junitTestCase{
+		Name:      urlMessage(ep),
+		Classname: site.Base,
+		Time:      junitSeconds(ep.Duration),
+	}
+	switch {
+	case ep.Error:
+		tc.Error = &junitProblem{
+			Message: fmt.Sprintf("%s error: %s", ep.ErrorCategory, ep.ErrorMessage),
+			Type:    ep.ErrorCategory,
+			Details: junitDetails(ep),
+		}
+	case !ep.Success:
+		tc.Failure = &junitProblem{
+			Message: fmt.Sprintf("expected BA %s, got %s (HTTP %s)", yesNo(ep.BaShouldBe), yesNo(ep.BaEnabled), ep.HTTPStatus),
+			Type:    "ba_mismatch",
+			Details: junitDetails(ep),
+		}
+		if ep.AssertionFailure != "" {
+			tc.Failure.Message = fmt.Sprintf("%s (HTTP %s)", ep.AssertionFailure, ep.HTTPStatus)
+			tc.Failure.Type = "assertion_failed"
+		}
+		if ep.ExpectRedirect != "" {
+			missing := "no redirect"
+			if ep.ExpectScheme != schemeRedirect {
+				missing = "no challenge or redirect"
+			}
+			tc.Failure.Message = fmt.Sprintf("%s to %s (HTTP %s)", missing, ep.ExpectRedirect, ep.HTTPStatus)
+			tc.Failure.Type = "redirect_missing"
+		}
+		if ep.BaEnabled && ep.ExpectRealm != "" && !matchRealm(ep.ExpectRealm, ep.Realm) {
+			tc.Failure.Message = fmt.Sprintf("wrong realm %q, wanted %q", ep.Realm, ep.ExpectRealm)
+			tc.Failure.Type = "realm_mismatch"
+		}
+		if ep.CredentialsChecked && !ep.CredentialsOK {
+			tc.Failure.Message = fmt.Sprintf("credentials rejected (HTTP %s)", ep.CredentialsStatus)
+			tc.Failure.Type = "credentials_rejected"
+		}
+	case ep.AcceptsArbitraryCredentials:
+		tc.Failure = &junitProblem{
+			Message: fmt.Sprintf("accepts arbitrary credentials (HTTP %s)", ep.InvalidCredentialsStatus),
+			Type:    "arbitrary_credentials",
+			Details: junitDetails(ep),
+		}
+	case len(ep.Findings) > 0:
+		tc.Failure = &junitProblem{
+			Message: fmt.Sprintf("%d finding(s)", len(ep.Findings)),
+			Type:    "finding",
+			Details: junitDetails(ep),
+		}
+	}
+	return tc
+}
+
+func newJUnitResult(sites []site, started time.Time) junitTestSuites {
+	result := junitTestSuites{Name: "ba_checker"}
+	var total time.Duration
+	for _, site := range sites {
+		sort.Sort(endpointSorter(site.endpoints))
+		suite := junitTestSuite{
+			Name:      site.Base,
+			Timestamp: started.Format("2006-01-02T15:04:05"),
+		}
+		var suiteTime time.Duration
+		for _, ep := range site.endpoints {
+			tc := newJUnitTestCase(site, ep)
+			switch {
+			case tc.Error != nil:
+				suite.Errors++
+			case tc.Failure != nil:
+				suite.Failures++
+			}
+			suiteTime += ep.Duration
+			suite.TestCases = append(suite.TestCases, tc)
+		}
+		suite.Tests = len(suite.TestCases)
+		suite.Time = junitSeconds(suiteTime)
+		result.Tests += suite.Tests
+		result.Failures += suite.Failures
+		result.Errors += suite.Errors
+		total += suiteTime
+		result.Suites = append(result.Suites, suite)
+	}
+	result.Time = junitSeconds(total)
+	return result
+}
+
+func printJUnitResult(sites []site, started time.Time) {
+	fmt.Print(xml.Header)
+	encoder := xml.NewEncoder(os.Stdout)
+	encoder.Indent("", "  ")
+	encoder.Encode(newJUnitResult(sites, started))
+	fmt.Println()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewJUnitResult(t *testing.T) {
+	sites := []site{
+		site{
+			Base: "https://example.com",
+			endpoints: []endpoint{
+				endpoint{URL: "https://example.com/admin", BaShouldBe: true, BaEnabled: true, Success: true,
+					HTTPStatus: "401 Unauthorized", Duration: 250 * time.Millisecond},
+				endpoint{URL: "https://example.com/private", BaShouldBe: true, Success: false,
+					HTTPStatus: "200 OK", Duration: 100 * time.Millisecond},
+			},
+		},
+		site{
+			Base: "https://down.example.com",
+			endpoints: []endpoint{
+				endpoint{URL: "https://down.example.com/", Error: true, ErrorCategory: errorConnect,
+					ErrorMessage: "connection refused"},
+			},
+		},
+	}
+	result := newJUnitResult(sites, time.Now())
+	if result.Tests != 3 || result.Failures != 1 || result.Errors != 1 || result.Time != "0.350" {
+		t.Errorf("Incorrect totals %+v", result)
+	}
+	if len(result.Suites) != 2 || result.Suites[0].Name != "https://example.com" {
+		t.Fatalf("Incorrect suites %+v", result.Suites)
+	}
+	failing := result.Suites[0].TestCases[1]
+	if failing.Name != "https://example.com/private" || failing.Failure == nil || failing.Time != "0.100" {
+		t.Fatalf("Incorrect failing test case %+v", failing)
+	}
+	if failing.Failure.Message != "expected BA yes, got no (HTTP 200 OK)" {
+		t.Errorf("Incorrect failure message %q", failing.Failure.Message)
+	}
+	errored := result.Suites[1].TestCases[0]
+	if errored.Error == nil || errored.Error.Type != errorConnect {
+		t.Errorf("Expected connect error, got %+v", errored.Error)
+	}
+
+	encoded, err := xml.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<testsuites name="ba_checker"`, `<testcase name="https://example.com/admin"`, `<failure message=`, `<error message=`} {
+		if !strings.Contains(string(encoded), want) {
+			t.Errorf("Missing %s in %s", want, encoded)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultNagiosMaxBytes fits the output in the 1 KiB packets of NRPE 2
+const defaultNagiosMaxBytes = 1024
+
+// nagiosLabels name the endpoints of the long output, in the order they are
+// listed. CRITICAL and WARNING are the findings and arbitrary credentials of
+// endpoints that passed otherwise.
+var nagiosLabels = []string{"FAILED", "CRITICAL", "ERROR", "UNKNOWN", "WARNING"}
+
+// threshold is a Nagios threshold range for the number of failed URLs, see
+// https://nagios-plugins.org/doc/guidelines.html#THRESHOLDFORMAT. With a %
+// it applies to the percentage of failed URLs instead.
+type threshold struct {
+	start    float64
+	end      float64
+	startInf bool
+	endInf   bool
+	inside   bool
+	percent  bool
+}
+
+// parseThreshold parses ranges like 10, 10:, ~:10, 10:20 and @10:20
+func parseThreshold(text string) (t threshold, err error) {
+	s := strings.TrimSpace(text)
+	if strings.HasPrefix(s, "@") {
+		t.inside = true
+		s = s[1:]
+	}
+	if strings.Contains(s, "%") {
+		t.percent = true
+		s = strings.Replace(s, "%", "", -1)
+	}
+	if s == "" {
+		return t, fmt.Errorf("invalid threshold %q", text)
+	}
+	start, end := "0", s
+	if i := strings.Index(s, ":"); i >= 0 {
+		start, end = s[:i], s[i+1:]
+	}
+	switch start {
+	case "~":
+		t.startInf = true
+	case "":
+		t.start = 0
+	default:
+		if t.start, err = strconv.ParseFloat(start, 64); err != nil {
+			return t, fmt.Errorf("invalid threshold %q", text)
+		}
+	}
+	if end == "" {
+		t.endInf = true
+	} else if t.end, err = strconv.ParseFloat(end, 64); err != nil {
+		return t, fmt.Errorf("invalid threshold %q", text)
+	}
+	if !t.startInf && !t.endInf && t.start > t.end {
+		return t, fmt.Errorf("invalid threshold %q, the start is greater than the end", text)
+	}
+	return t, nil
+}
+
+// alert tells whether the number of failed URLs out of total is outside the
+// range, or inside it for ranges starting with @
+func (t threshold) alert(failures int, total int) bool {
+	value := float64(failures)
+	if t.percent {
+		value = 0
+		if total > 0 {
+			value = 100 * float64(failures) / float64(total)
+		}
+	}
+	outside := (!t.startInf && value < t.start) || (!t.endInf && value > t.end)
+	return outside != t.inside
+}
+
+// UnmarshalTOML reads the warning and critical of a site, a number or a range
+func (t *threshold) UnmarshalTOML(data interface{}) error {
+	var text string
+	switch v := data.(type) {
+	case int64:
+		text = strconv.FormatInt(v, 10)
+	case string:
+		text = v
+	default:
+		return fmt.Errorf("invalid threshold %v, use a number or a string", data)
+	}
+	parsed, err := parseThreshold(text)
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// latencies returns the total, average and maximum request duration of the
+// endpoints that were checked
+func latencies(sites []site) (total time.Duration, average time.Duration, max time.Duration) {
+	count := 0
+	for _, site := range sites {
+		for _, ep := range site.endpoints {
+			if ep.Attempts == 0 {
+				continue
+			}
+			count++
+			total += ep.Duration
+			if ep.Duration > max {
+				max = ep.Duration
+			}
+		}
+	}
+	if count > 0 {
+		average = total / time.Duration(count)
+	}
+	return total, average, max
+}
+
+// nagiosPerfdata formats the performance data following the summary. The
+// failed count has no thresholds, they apply to each site on its own.
+func nagiosPerfdata(sites []site, okCount int, failures int, unknowns int, errorCount int) string {
+	totalURLs := numberOfTotalURLs(sites)
+	total, average, max := latencies(sites)
+	return strings.Join([]string{
+		fmt.Sprintf("ok=%d;;;0;%d", okCount, totalURLs),
+		fmt.Sprintf("failed=%d;;;0;%d", failures, totalURLs),
+		fmt.Sprintf("unknown=%d;;;0;%d", unknowns, totalURLs),
+		fmt.Sprintf("errors=%d;;;0;%d", errorCount, totalURLs),
+		fmt.Sprintf("total=%d", totalURLs),
+		fmt.Sprintf("latency_total=%.3fs", total.Seconds()),
+		fmt.Sprintf("latency_avg=%.3fs", average.Seconds()),
+		fmt.Sprintf("latency_max=%.3fs", max.Seconds()),
+	}, " ")
+}
+
+// nagiosLabel returns the label of an endpoint in the long output, or "" for
+// endpoints that are fine
+func nagiosLabel(ep endpoint) string {
+	switch {
+	case ep.Error:
+		return "ERROR"
+	case ep.Unknown:
+		return "UNKNOWN"
+	case !ep.Success:
+		return "FAILED"
+	}
+	return ""
+}
+
+// nagiosSiteLine is the status of a site in the long output
+func nagiosSiteLine(s site) string {
+	return fmt.Sprintf("%s: site %s - OK: %d/%d",
+		lookUpStatusCodeMap[s.status], s.Base, getTotalOK([]site{s}), len(s.endpoints))
+}
+
+// nagiosEndpointLine describes a failed, errored or unknown endpoint in the
+// long output, with the challenge and the credentials when they went wrong
+func nagiosEndpointLine(label string, ep endpoint) string {
+	line := fmt.Sprintf("%s: %s - BA %s, wanted %s (%s)",
+		label, urlMessage(ep), baMessage(ep), baWantedMessage(ep), wantedSchemeMessage(ep))
+	switch message := challengeMessage(ep); message {
+	case "", "ok", "redirect ok", "missing":
+	default:
+		line += ", challenge " + message
+	}
+	if message := credentialsMessage(ep); message != "" && message != "ok" {
+		line += ", credentials " + message
+	}
+	return fmt.Sprintf("%s, %s", line, httpStatusMessage(ep))
+}
+
+// nagiosFindingLine describes a finding of an endpoint in the long output
+func nagiosFindingLine(ep endpoint, f finding) string {
+	line := fmt.Sprintf("%s: %s - %s, %s %s", strings.ToUpper(f.Severity), urlMessage(ep), f.Check, f.Method, f.URL)
+	if len(f.Headers) > 0 {
+		line += " with " + strings.Replace(headersMessage(f.Headers), "\n", ", ", -1)
+	}
+	return fmt.Sprintf("%s, %s", line, f.Status)
+}
+
+// nagiosLongOutput lists the sites that are not OK, worst first, then the
+// failed, errored and unknown endpoints and the findings, by severity, and
+// last the sites that are OK. There is one site, endpoint or finding per line.
+func nagiosLongOutput(sites []site) []string {
+	sorted := make([]site, len(sites))
+	copy(sorted, sites)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return statusSeverity[sorted[i].status] > statusSeverity[sorted[j].status]
+	})
+	var output, okSites []string
+	for _, site := range sorted {
+		if site.status == 0 {
+			okSites = append(okSites, nagiosSiteLine(site))
+		} else {
+			output = append(output, nagiosSiteLine(site))
+		}
+	}
+	lines := map[string][]string{}
+	for _, site := range sites {
+		sort.Sort(endpointSorter(site.endpoints))
+		for _, ep := range site.endpoints {
+			if label := nagiosLabel(ep); label != "" {
+				lines[label] = append(lines[label], nagiosEndpointLine(label, ep))
+			}
+			for _, f := range ep.Findings {
+				label := strings.ToUpper(f.Severity)
+				lines[label] = append(lines[label], nagiosFindingLine(ep, f))
+			}
+			if ep.AcceptsArbitraryCredentials {
+				lines["WARNING"] = append(lines["WARNING"], fmt.Sprintf("WARNING: %s - accepts arbitrary credentials, %s",
+					urlMessage(ep), ep.InvalidCredentialsStatus))
+			}
+		}
+	}
+	for _, label := range nagiosLabels {
+		output = append(output, lines[label]...)
+	}
+	return append(output, okSites...)
+}
+
+// limitOutput joins the summary, its perfdata and as many lines of the long
+// output as fit in maxBytes, noting how many were left out. A maxBytes of 0
+// is no limit. If not even the first line fits, the perfdata is dropped
+// rather than cut, and the summary is cut between words.
+func limitOutput(summary string, perfdata string, lines []string, maxBytes int) string {
+	first := summary + " | " + perfdata
+	for n := len(lines); n >= 0; n-- {
+		output := strings.Join(append([]string{first}, lines[:n]...), "\n")
+		if n < len(lines) {
+			output += fmt.Sprintf("\n... %d more not shown", len(lines)-n)
+		}
+		if maxBytes <= 0 || len(output) <= maxBytes {
+			return output
+		}
+	}
+	for len(summary) > maxBytes {
+		i := strings.LastIndex(summary[:maxBytes+1], " ")
+		if i < 0 {
+			return ""
+		}
+		summary = summary[:i]
+	}
+	return summary
+}
+
+func printNagiosResult(sites []site, statusCode int, maxBytes int) {
+	totalURLs := numberOfTotalURLs(sites)
+	failures, unknowns := getTotalFailuresAndUnknowns(sites)
+	errorCount := getTotalErrors(sites)
+	okCount := getTotalOK(sites)
+	result := fmt.Sprintf("BA check: %s - OK: %d/%d",
+		lookUpStatusCodeMap[statusCode],
+		okCount,
+		totalURLs)
+	if unknowns > 0 {
+		result += fmt.Sprintf(" Unknowns: %d", unknowns)
+	}
+	if errorCount > 0 {
+		result += fmt.Sprintf(" Errors: %d", errorCount)
+	}
+	if arbitrary := getTotalArbitraryCredentials(sites); arbitrary > 0 {
+		result += fmt.Sprintf(" Accepts arbitrary credentials: %d", arbitrary)
+	}
+	if findings := getTotalFindings(sites); findings > 0 {
+		result += fmt.Sprintf(" Findings: %d", findings)
+	}
+	perfdata := nagiosPerfdata(sites, okCount, failures, unknowns, errorCount)
+	fmt.Println(limitOutput(result, perfdata, nagiosLongOutput(sites), maxBytes))
+}
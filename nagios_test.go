@@ -0,0 +1,235 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// countThreshold is the range alerting at n or more failed URLs, what the
+// integer thresholds meant before they became Nagios ranges
+func countThreshold(n int) threshold {
+	return threshold{end: float64(n - 1)}
+}
+
+func TestThresholdAlert(t *testing.T) {
+	testCases := []struct {
+		text     string
+		failures int
+		total    int
+		alert    bool
+	}{
+		{"0", 0, 10, false},
+		{"0", 1, 10, true},
+		{"10", 10, 20, false},
+		{"10", 11, 20, true},
+		{"10:", 9, 20, true},
+		{"10:", 10, 20, false},
+		{"~:10", 11, 20, true},
+		{"~:10", 0, 20, false},
+		{"10:20", 9, 30, true},
+		{"10:20", 15, 30, false},
+		{"10:20", 21, 30, true},
+		{"@10:20", 9, 30, false},
+		{"@10:20", 10, 30, true},
+		{"@10:20", 20, 30, true},
+		{"10%", 1, 10, false},
+		{"10%", 2, 10, true},
+		{"@50%:", 5, 10, true},
+		{"@50%:", 4, 10, false},
+		{"10%", 0, 0, false},
+	}
+	for _, tc := range testCases {
+		th, err := parseThreshold(tc.text)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %s", tc.text, err)
+			continue
+		}
+		if got := th.alert(tc.failures, tc.total); got != tc.alert {
+			t.Errorf("Incorrect alert %t for %q with %d/%d failures", got, tc.text, tc.failures, tc.total)
+		}
+	}
+
+	for _, text := range []string{"", "a", "10:5", "1:b", "@"} {
+		if _, err := parseThreshold(text); err == nil {
+			t.Errorf("Expected error for threshold %q", text)
+		}
+	}
+}
+
+func TestCountThreshold(t *testing.T) {
+	th := countThreshold(2)
+	if th.alert(1, 10) || !th.alert(2, 10) {
+		t.Errorf("Incorrect count threshold %+v", th)
+	}
+}
+
+func TestCheckStatusPercentage(t *testing.T) {
+	sites := []site{
+		site{endpoints: []endpoint{
+			endpoint{Success: false},
+			endpoint{Success: true},
+			endpoint{Success: true},
+			endpoint{Success: true},
+		}},
+	}
+	warning, _ := parseThreshold("20%")
+	critical, _ := parseThreshold("50%")
+	if status := checkStatus(sites, warning, critical); status != 1 {
+		t.Errorf("Incorrect status %d with 25%% failed", status)
+	}
+	sites[0].endpoints[1].Success = false
+	if status := checkStatus(sites, warning, critical); status != 1 {
+		t.Errorf("Incorrect status %d with 50%% failed", status)
+	}
+	sites[0].endpoints[2].Success = false
+	if status := checkStatus(sites, warning, critical); status != 2 {
+		t.Errorf("Incorrect status %d with 75%% failed", status)
+	}
+}
+
+func TestNagiosPerfdata(t *testing.T) {
+	sites := []site{
+		site{endpoints: []endpoint{
+			endpoint{Success: true, Attempts: 1, Duration: 100 * time.Millisecond},
+			endpoint{Success: false, Attempts: 1, Duration: 300 * time.Millisecond},
+			endpoint{Error: true},
+		}},
+	}
+	expected := "ok=1;;;0;3 failed=1;;;0;3 unknown=0;;;0;3 errors=1;;;0;3 total=3 " +
+		"latency_total=0.400s latency_avg=0.200s latency_max=0.300s"
+	if got := nagiosPerfdata(sites, 1, 1, 0, 1); got != expected {
+		t.Errorf("Incorrect perfdata\n got: %s\nwant: %s", got, expected)
+	}
+}
+
+func TestNagiosLongOutput(t *testing.T) {
+	sites := []site{
+		site{Base: "https://example.com", endpoints: []endpoint{
+			endpoint{URL: "https://example.com/unknown", Success: true, Unknown: true, HTTPStatus: "404 Not Found"},
+			endpoint{URL: "https://example.com/ok", BaShouldBe: true, BaEnabled: true, Success: true, HTTPStatus: "401 Unauthorized"},
+			endpoint{URL: "https://example.com/error", BaShouldBe: true, Error: true, ErrorCategory: "timeout", ErrorMessage: "deadline exceeded"},
+		}},
+		site{Base: "https://example.net", endpoints: []endpoint{
+			endpoint{URL: "https://example.net/", Success: true, HTTPStatus: "200 OK"},
+		}},
+		site{Base: "https://example.org", endpoints: []endpoint{
+			endpoint{URL: "https://example.org/admin", Method: "POST", BaShouldBe: true, HTTPStatus: "200 OK"},
+		}},
+		site{Base: "http://example.info", endpoints: []endpoint{
+			endpoint{URL: "http://example.info/admin", BaShouldBe: true, BaEnabled: true, Success: true, HTTPStatus: "401 Unauthorized",
+				Findings: []finding{
+					finding{Check: policyPlainHTTP, Severity: severityCritical, Method: "GET", URL: "http://example.info/admin", Status: "401 Unauthorized"},
+					finding{Check: auditHeaders, Severity: severityWarning, Method: "GET", URL: "http://example.info/admin",
+						Headers: map[string]string{"X-Forwarded-For": "127.0.0.1"}, Status: "200 OK"},
+				}},
+			endpoint{URL: "http://example.info/api", BaShouldBe: true, BaEnabled: true, Success: true, HTTPStatus: "401 Unauthorized",
+				AcceptsArbitraryCredentials: true, InvalidCredentialsStatus: "200 OK"},
+		}},
+		site{Base: "https://example.biz", endpoints: []endpoint{
+			endpoint{URL: "https://example.biz/admin", BaShouldBe: true, BaEnabled: true, HTTPStatus: "401 Unauthorized",
+				ExpectRealm: "admin", Realm: "staging"},
+			endpoint{URL: "https://example.biz/api", BaShouldBe: true, BaEnabled: true, HTTPStatus: "401 Unauthorized",
+				CredentialsChecked: true, CredentialsStatus: "403 Forbidden"},
+			endpoint{URL: "https://example.biz/health", HTTPStatus: "200 OK", AssertionFailure: `body does not contain "ok"`},
+		}},
+	}
+	checkStatus(sites, countThreshold(1), countThreshold(2))
+	expected := []string{
+		"CRITICAL: site http://example.info - OK: 0/2",
+		"CRITICAL: site https://example.biz - OK: 0/3",
+		"UNKNOWN: site https://example.com - OK: 1/3",
+		"WARNING: site https://example.org - OK: 0/1",
+		"FAILED: POST https://example.org/admin - BA no, wanted yes (basic), 200 OK",
+		`FAILED: https://example.biz/admin - BA yes, wanted yes (basic), challenge wrong realm "staging", 401 Unauthorized`,
+		"FAILED: https://example.biz/api - BA yes, wanted yes (basic), credentials rejected (403 Forbidden), 401 Unauthorized",
+		`FAILED: https://example.biz/health - BA no, wanted no (none), 200 OK (body does not contain "ok")`,
+		"CRITICAL: http://example.info/admin - plain_http, GET http://example.info/admin, 401 Unauthorized",
+		"ERROR: https://example.com/error - BA error, wanted yes (basic), timeout error: deadline exceeded",
+		"UNKNOWN: https://example.com/unknown - BA unknown, wanted no (none), 404 Not Found",
+		"WARNING: http://example.info/admin - headers, GET http://example.info/admin with X-Forwarded-For: 127.0.0.1, 200 OK",
+		"WARNING: http://example.info/api - accepts arbitrary credentials, 200 OK",
+		"OK: site https://example.net - OK: 1/1",
+	}
+	lines := nagiosLongOutput(sites)
+	if len(lines) != len(expected) {
+		t.Fatalf("Incorrect long output %q", lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("Incorrect line %d\n got: %s\nwant: %s", i, lines[i], expected[i])
+		}
+	}
+}
+
+func TestSiteThresholds(t *testing.T) {
+	var config configuration
+	_, err := toml.Decode(`
+[[site]]
+base = "https://production.example.com"
+critical = 0
+
+[[site]]
+base = "https://staging.example.com"
+warning = "2"
+critical = "50%"
+`, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sites := config.Sites
+	sites[0].endpoints = []endpoint{endpoint{Success: false}, endpoint{Success: true}}
+	sites[1].endpoints = []endpoint{endpoint{Success: false}, endpoint{Success: true}, endpoint{Success: true}}
+	if status := checkStatus(sites, countThreshold(1), countThreshold(2)); status != 2 {
+		t.Errorf("Incorrect status %d", status)
+	}
+	if sites[0].status != 2 || sites[1].status != 0 {
+		t.Errorf("Incorrect site status %d and %d", sites[0].status, sites[1].status)
+	}
+
+	sites[0].endpoints[0].Success = true
+	sites[1].endpoints[1].Success = false
+	sites[1].endpoints[2].Success = false
+	if status := checkStatus(sites, countThreshold(1), countThreshold(2)); status != 2 {
+		t.Errorf("Incorrect status %d", status)
+	}
+	if sites[0].status != 0 || sites[1].status != 2 {
+		t.Errorf("Incorrect site status %d and %d", sites[0].status, sites[1].status)
+	}
+
+	for _, invalid := range []string{`warning = "5:1"`, `critical = 1.5`} {
+		if _, err := toml.Decode("[[site]]\n"+invalid, &configuration{}); err == nil {
+			t.Errorf("Expected error for %s", invalid)
+		}
+	}
+}
+
+func TestLimitOutput(t *testing.T) {
+	summary, perfdata := "BA check: OK - OK: 10/12", "ok=10;;;0;12"
+	first := "BA check: OK - OK: 10/12 | ok=10;;;0;12"
+	lines := []string{"FAILED: https://example.com/a", "FAILED: https://example.com/b", "ERROR: https://example.com/c"}
+	testCases := []struct {
+		maxBytes int
+		expected string
+	}{
+		{0, first + "\nFAILED: https://example.com/a\nFAILED: https://example.com/b\nERROR: https://example.com/c"},
+		{200, first + "\nFAILED: https://example.com/a\nFAILED: https://example.com/b\nERROR: https://example.com/c"},
+		{120, first + "\nFAILED: https://example.com/a\nFAILED: https://example.com/b\n... 1 more not shown"},
+		{90, first + "\nFAILED: https://example.com/a\n... 2 more not shown"},
+		{60, first + "\n... 3 more not shown"},
+		{45, "BA check: OK - OK: 10/12"},
+		{20, "BA check: OK - OK:"},
+		{4, "BA"},
+		{1, ""},
+	}
+	for _, tc := range testCases {
+		got := limitOutput(summary, perfdata, lines, tc.maxBytes)
+		if got != tc.expected {
+			t.Errorf("Incorrect output with %d bytes: %q", tc.maxBytes, got)
+		}
+		if tc.maxBytes > 0 && len(got) > tc.maxBytes {
+			t.Errorf("Output of %d bytes exceeds %d", len(got), tc.maxBytes)
+		}
+	}
+}
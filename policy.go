@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	policyPlainHTTP     = "plain_http"
+	policyHTTPSRedirect = "https_redirect"
+)
+
+// loadPlainHTTPSeverity validates the site's plain_http_severity, which
+// defaults to critical
+func loadPlainHTTPSeverity(site *site) error {
+	if site.PlainHTTPSeverity == "" {
+		site.PlainHTTPSeverity = severityCritical
+	}
+	site.PlainHTTPSeverity = strings.ToLower(site.PlainHTTPSeverity)
+	if _, ok := severityStatus[site.PlainHTTPSeverity]; !ok {
+		return fmt.Errorf("invalid plain_http_severity %q, use %s or %s",
+			site.PlainHTTPSeverity, severityWarning, severityCritical)
+	}
+	return nil
+}
+
+func isPlainHTTP(URL string) bool {
+	return strings.HasPrefix(strings.ToLower(URL), "http://")
+}
+
+// basicChallenge tells whether the response challenges for Basic Auth
+func basicChallenge(response *http.Response) bool {
+	if response.StatusCode != 401 {
+		return false
+	}
+	_, ok := findScheme(parseChallenges(response.Header["Www-Authenticate"]), []string{schemeBasic})
+	return ok
+}
+
+// checkPlainHTTP flags Basic challenges served without TLS, as the
+// credentials would be sent in cleartext
+func checkPlainHTTP(ctx context.Context, ep *endpoint, response *http.Response) {
+	severity := ep.plainHTTPSeverity
+	if severity == "" {
+		severity = severityCritical
+	}
+	// The challenge is served by the last hop, a redirect to HTTPS first is fine
+	challengeURL := response.Request.URL.String()
+	if isPlainHTTP(challengeURL) && basicChallenge(response) {
+		ep.Findings = append(ep.Findings, finding{
+			Check:      policyPlainHTTP,
+			Severity:   severity,
+			Method:     response.Request.Method,
+			URL:        challengeURL,
+			StatusCode: response.StatusCode,
+			Status:     response.Status,
+		})
+	}
+	if ep.checkHTTPSRedirect && strings.HasPrefix(strings.ToLower(ep.URL), "https://") {
+		checkHTTPSRedirect(ctx, ep, severity)
+	}
+}
+
+// plainVariant returns the http:// variant of an https:// URL. An explicit
+// port is dropped, the TLS port would not answer plain HTTP.
+func plainVariant(URL string) string {
+	u, err := url.Parse(URL)
+	if err != nil {
+		return "http://" + URL[len("https://"):]
+	}
+	u.Scheme = "http"
+	u.Host = u.Hostname()
+	if strings.Contains(u.Host, ":") {
+		u.Host = "[" + u.Host + "]"
+	}
+	return u.String()
+}
+
+// checkHTTPSRedirect requests the http:// variant of the endpoint, which
+// should redirect to HTTPS before challenging. Not getting an answer at all
+// is fine, nothing is served over plain HTTP then.
+func checkHTTPSRedirect(ctx context.Context, ep *endpoint, severity string) {
+	plain := *ep
+	plain.URL = plainVariant(ep.URL)
+	plain.client = withoutRedirects(ep.client)
+	req, err := newRequest(ctx, &plain)
+	if err != nil {
+		return
+	}
+	response, err := doRequest(ctx, &plain, req)
+	if err != nil {
+		return
+	}
+	response.Body.Close()
+	if location, err := response.Location(); err == nil && location.Scheme == "https" {
+		return
+	}
+	check := policyHTTPSRedirect
+	if basicChallenge(response) {
+		check = policyPlainHTTP
+	}
+	ep.Findings = append(ep.Findings, finding{
+		Check:      check,
+		Severity:   severity,
+		Method:     req.Method,
+		URL:        plain.URL,
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+	})
+}
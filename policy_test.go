@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestCheckPlainHTTP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="legacy"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+	testCases := []struct {
+		severity string
+		status   int
+	}{
+		{"", 2},
+		{"Warning", 1},
+		{"critical", 2},
+	}
+	for _, tc := range testCases {
+		sites := []site{site{Base: ts.URL, BasicAuth: []string{"admin"}, PlainHTTPSeverity: tc.severity}}
+		if err := populateURLConfig(sites); err != nil {
+			t.Fatal(err)
+		}
+		checkSites(context.Background(), sites, 1)
+		ep := sites[0].endpoints[0]
+		if !ep.Success {
+			t.Errorf("Expected endpoint to be protected")
+		}
+		if len(ep.Findings) != 1 || ep.Findings[0].Check != policyPlainHTTP {
+			t.Fatalf("Incorrect findings %+v", ep.Findings)
+		}
+		if status := checkStatus(sites, countThreshold(1), countThreshold(2)); status != tc.status {
+			t.Errorf("Incorrect status %d for severity %q, wanted %d", status, tc.severity, tc.status)
+		}
+	}
+
+	invalid := []site{site{Base: ts.URL, PlainHTTPSeverity: "ignore"}}
+	if err := populateURLConfig(invalid); err == nil {
+		t.Error("Expected error for invalid plain_http_severity")
+	}
+}
+
+func TestCheckPlainHTTPRedirectedToHTTPS(t *testing.T) {
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer secure.Close()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, secure.URL+r.URL.Path, http.StatusMovedPermanently)
+	}))
+	defer plain.Close()
+
+	sites := []site{site{Base: plain.URL, BasicAuth: []string{"admin"}, TLS: &tlsOptions{InsecureSkipVerify: true}}}
+	if err := populateURLConfig(sites); err != nil {
+		t.Fatal(err)
+	}
+	checkSites(context.Background(), sites, 1)
+	ep := sites[0].endpoints[0]
+	if !ep.Success || len(ep.Findings) != 0 {
+		t.Errorf("Incorrect result for a redirect to HTTPS: success %t, findings %+v", ep.Success, ep.Findings)
+	}
+	if status := checkStatus(sites, countThreshold(1), countThreshold(2)); status != 0 {
+		t.Errorf("Incorrect status %d", status)
+	}
+}
+
+func TestCheckHTTPSRedirect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "https://"+r.Host+r.URL.Path, http.StatusMovedPermanently)
+		case "/challenge":
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+	// The http:// variant of these URLs goes to the default port, which is
+	// dialed as the plain HTTP test server
+	var (
+		mu     sync.Mutex
+		dialed []string
+	)
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			mu.Lock()
+			dialed = append(dialed, addr)
+			mu.Unlock()
+			return (&net.Dialer{}).DialContext(ctx, network, ts.Listener.Addr().String())
+		},
+	}}
+	base := "https://example.test:8443"
+	testCases := []struct {
+		path  string
+		check string
+	}{
+		{"/redirect", ""},
+		{"/challenge", policyPlainHTTP},
+		{"/served", policyHTTPSRedirect},
+	}
+	for _, tc := range testCases {
+		ep := endpoint{URL: base + tc.path, BaShouldBe: true, client: client}
+		checkHTTPSRedirect(context.Background(), &ep, severityWarning)
+		check := ""
+		if len(ep.Findings) > 0 {
+			check = ep.Findings[0].Check
+		}
+		if check != tc.check {
+			t.Errorf("Incorrect finding %q for %s, wanted %q", check, tc.path, tc.check)
+		}
+		if check != "" && ep.Findings[0].URL != "http://example.test"+tc.path {
+			t.Errorf("Incorrect finding URL %s", ep.Findings[0].URL)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, addr := range dialed {
+		if addr != "example.test:80" {
+			t.Errorf("Plain HTTP variant dialed %s, wanted the default port", addr)
+		}
+	}
+}
+
+func TestPlainVariant(t *testing.T) {
+	testCases := map[string]string{
+		"https://example.com/admin":       "http://example.com/admin",
+		"https://example.com:8443/admin":  "http://example.com/admin",
+		"https://[::1]:8443/admin?x=1":    "http://[::1]/admin?x=1",
+		"https://example.com/basic/:user": "http://example.com/basic/:user",
+	}
+	for URL, expected := range testCases {
+		if got := plainVariant(URL); got != expected {
+			t.Errorf("Incorrect plain variant of %s: %s, wanted %s", URL, got, expected)
+		}
+	}
+}
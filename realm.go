@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// patternRegexp returns the regular expression of a pattern written as
+// /.../, or nil for a plain string
+func patternRegexp(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) < 2 || !strings.HasPrefix(pattern, "/") || !strings.HasSuffix(pattern, "/") {
+		return nil, nil
+	}
+	return regexp.Compile(pattern[1 : len(pattern)-1])
+}
+
+// matchRealm tells whether the realm matches the expected realm pattern
+func matchRealm(pattern string, realm string) bool {
+	re, err := patternRegexp(pattern)
+	if err != nil {
+		return false
+	}
+	if re != nil {
+		return re.MatchString(realm)
+	}
+	return realm == pattern
+}
+
+// printRealmsReport groups the protected endpoints of every site by the realm
+// they challenge with. More than one realm for a site usually means that a
+// different vhost answered some of them.
+func printRealmsReport(sites []site, statusCode int) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetBorders(tablewriter.Border{Left: false, Top: false, Right: false, Bottom: true})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader([]string{"Site", "Realm", "URL", "Wanted Realm", "Success"})
+	inconsistent := 0
+	for _, site := range sites {
+		realms := map[string][]endpoint{}
+		for _, ep := range site.endpoints {
+			if ep.BaShouldBe {
+				realms[ep.Realm] = append(realms[ep.Realm], ep)
+			}
+		}
+		if len(realms) > 1 {
+			inconsistent++
+		}
+		names := make([]string, 0, len(realms))
+		for realm := range realms {
+			names = append(names, realm)
+		}
+		sort.Strings(names)
+		siteCell := site.Base
+		for _, realm := range names {
+			realmCell := fmt.Sprintf("%q", realm)
+			if realm == "" {
+				realmCell = "(none)"
+			}
+			sort.Sort(endpointSorter(realms[realm]))
+			for _, ep := range realms[realm] {
+				table.Append([]string{siteCell, realmCell, urlMessage(ep), ep.ExpectRealm, successMessage(ep)})
+				siteCell, realmCell = "", ""
+			}
+		}
+	}
+	table.Render()
+	fmt.Printf("\nSites with more than one realm: %d\n", inconsistent)
+	fmt.Printf("Status: %s\n", lookUpStatusCodeMap[statusCode])
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchRealm(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		realm   string
+		match   bool
+	}{
+		{"Admin area", "Admin area", true},
+		{"Admin area", "admin area", false},
+		{"/^Admin/", "Admin area", true},
+		{"/^Admin/", "Staging Admin", false},
+		{"/(?i)staging|test/", "Test environment", true},
+		{"/", "/", true},
+		{"/[/", "[", false},
+	}
+	for _, tc := range testCases {
+		if got := matchRealm(tc.pattern, tc.realm); got != tc.match {
+			t.Errorf("matchRealm(%q, %q) = %t, wanted %t", tc.pattern, tc.realm, got, tc.match)
+		}
+	}
+}
+
+func TestCheckURLRealm(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		realm := "Intranet"
+		if r.URL.Path == "/other" {
+			realm = "Default vhost"
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+	sites := []site{
+		site{
+			Base:              ts.URL,
+			BasicAuth:         []string{"admin", "other"},
+			Realm:             "/^Intranet$/",
+			PlainHTTPSeverity: severityWarning,
+			Endpoints: []endpointConfig{
+				{Path: "override", Realm: "Intranet"},
+				{Path: "wrong", Realm: "Extranet"},
+			},
+		},
+	}
+	if err := populateURLConfig(sites); err != nil {
+		t.Fatal(err)
+	}
+	checkSites(context.Background(), sites, 2)
+	wanted := map[string]bool{
+		ts.URL + "/admin":    true,
+		ts.URL + "/other":    false,
+		ts.URL + "/override": true,
+		ts.URL + "/wrong":    false,
+	}
+	for _, ep := range sites[0].endpoints {
+		if ep.Success != wanted[ep.URL] {
+			t.Errorf("Incorrect success %t for %s with realm %q, wanted realm %q", ep.Success, ep.URL, ep.Realm, ep.ExpectRealm)
+		}
+		if !ep.Success && challengeMessage(ep) != `wrong realm "`+ep.Realm+`"` {
+			t.Errorf("Incorrect challenge message %q", challengeMessage(ep))
+		}
+	}
+
+	invalid := []site{site{Base: ts.URL, Realm: "/[/"}}
+	if err := populateURLConfig(invalid); err == nil {
+		t.Error("Expected error for invalid realm regex")
+	}
+	invalid = []site{site{Base: ts.URL, Endpoints: []endpointConfig{{Path: "x", Realm: "/(/"}}}}
+	if err := populateURLConfig(invalid); err == nil {
+		t.Error("Expected error for invalid endpoint realm regex")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	redirectFollow      = "follow"
+	redirectNoFollow    = "no_follow"
+	defaultMaxRedirects = 10
+
+	// schemeRedirect is the expected "scheme" of endpoints protected by a
+	// redirect to a login page instead of a challenge
+	schemeRedirect = "redirect"
+)
+
+// loadRedirectPolicy validates the site's redirect policy
+func loadRedirectPolicy(site *site) error {
+	site.Redirect = strings.ToLower(site.Redirect)
+	if site.Redirect == "" {
+		site.Redirect = redirectFollow
+	}
+	if site.Redirect != redirectFollow && site.Redirect != redirectNoFollow {
+		return fmt.Errorf("invalid redirect %q, use %s or %s", site.Redirect, redirectFollow, redirectNoFollow)
+	}
+	if site.MaxRedirects < 0 {
+		return fmt.Errorf("max_redirects must not be negative, got %d", site.MaxRedirects)
+	}
+	if _, err := patternRegexp(site.RedirectTo); err != nil {
+		return fmt.Errorf("invalid redirect_to: %s", err)
+	}
+	return nil
+}
+
+// checkRedirect returns the CheckRedirect function of the site's client.
+// Redirects that are not followed are returned as the response.
+func checkRedirect(site *site) func(*http.Request, []*http.Request) error {
+	noFollow := site.Redirect == redirectNoFollow
+	maxRedirects := site.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		if noFollow || len(via) > maxRedirects {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+}
+
+// redirectChain returns the URLs the request was redirected to, in order,
+// including the target of a final redirect that wasn't followed
+func redirectChain(response *http.Response) []string {
+	var chain []string
+	for req := response.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		chain = append([]string{req.URL.String()}, chain...)
+	}
+	if location, err := response.Location(); err == nil {
+		chain = append(chain, location.String())
+	}
+	return chain
+}
+
+// matchURLPattern tells whether the URL starts with the pattern, or matches
+// it if it is written as /.../
+func matchURLPattern(pattern string, URL string) bool {
+	re, err := patternRegexp(pattern)
+	if err != nil {
+		return false
+	}
+	if re != nil {
+		return re.MatchString(URL)
+	}
+	return strings.HasPrefix(URL, pattern)
+}
+
+// checkRedirectExpectation marks the endpoint protected if it was redirected
+// to a URL matching redirect_to, like the login page of an SSO
+func checkRedirectExpectation(ep *endpoint) {
+	if ep.ExpectScheme != schemeRedirect && ep.BaEnabled {
+		// The challenge is accepted as well, see populateURLConfig
+		return
+	}
+	for _, URL := range ep.Redirects {
+		if matchURLPattern(ep.ExpectRedirect, URL) {
+			ep.Redirected = true
+			break
+		}
+	}
+	ep.Success = ep.Redirected
+	ep.Unknown = false
+}
+
+// redirectMessage describes where the endpoint was redirected to
+func redirectMessage(ep endpoint) string {
+	if len(ep.Redirects) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("redirected to %s", ep.Redirects[len(ep.Redirects)-1])
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newRedirectServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app":
+			http.Redirect(w, r, "/sso/login?return=/app", http.StatusFound)
+		case "/sso/login":
+			fmt.Fprintln(w, "Please log in")
+		case "/basic", "/legacy":
+			w.Header().Set("WWW-Authenticate", `Basic realm="basic"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		}
+	}))
+}
+
+func TestCheckURLRedirectTo(t *testing.T) {
+	ts := newRedirectServer()
+	defer ts.Close()
+	for _, policy := range []string{"", "Follow", "no_follow"} {
+		sites := []site{
+			site{
+				Base:              ts.URL,
+				BasicAuth:         []string{"app", "public", "legacy"},
+				RedirectTo:        ts.URL + "/sso/",
+				Redirect:          policy,
+				PlainHTTPSeverity: severityWarning,
+				Endpoints: []endpointConfig{
+					{Path: "basic", ExpectScheme: "basic"},
+					{Path: "regex", RedirectTo: `/\/sso\/login\?return=/`},
+				},
+			},
+		}
+		if err := populateURLConfig(sites); err != nil {
+			t.Fatal(err)
+		}
+		checkSites(context.Background(), sites, 2)
+		wanted := map[string]bool{
+			ts.URL + "/app":    true,
+			ts.URL + "/public": false,
+			ts.URL + "/legacy": true,
+			ts.URL + "/basic":  true,
+			ts.URL + "/regex":  false,
+		}
+		for _, ep := range sites[0].endpoints {
+			if ep.Success != wanted[ep.URL] {
+				t.Errorf("Incorrect success %t for %s with redirect policy %q, redirects %q",
+					ep.Success, ep.URL, policy, ep.Redirects)
+			}
+			if ep.URL != ts.URL+"/app" {
+				continue
+			}
+			if len(ep.Redirects) != 1 || ep.Redirects[0] != ts.URL+"/sso/login?return=/app" {
+				t.Errorf("Incorrect redirect chain %q", ep.Redirects)
+			}
+			status := 200
+			if policy == "no_follow" {
+				status = 302
+			}
+			if ep.HTTPStatusCode != status {
+				t.Errorf("Incorrect status %d with redirect policy %q, wanted %d", ep.HTTPStatusCode, policy, status)
+			}
+		}
+	}
+}
+
+func TestMaxRedirects(t *testing.T) {
+	ts := newRedirectServer()
+	defer ts.Close()
+	sites := []site{site{Base: ts.URL, NoBasicAuth: []string{"loop"}, MaxRedirects: 2}}
+	if err := populateURLConfig(sites); err != nil {
+		t.Fatal(err)
+	}
+	checkSites(context.Background(), sites, 1)
+	ep := sites[0].endpoints[0]
+	if ep.Error || ep.HTTPStatusCode != 302 {
+		t.Errorf("Expected the last redirect as response, got %d (%s)", ep.HTTPStatusCode, ep.ErrorMessage)
+	}
+	if len(ep.Redirects) != 3 {
+		t.Errorf("Incorrect redirect chain %q, wanted 2 followed redirects and the last one", ep.Redirects)
+	}
+}
+
+func TestLoadRedirectPolicy(t *testing.T) {
+	invalid := [][]site{
+		{site{Base: "https://example.com", Redirect: "sometimes"}},
+		{site{Base: "https://example.com", MaxRedirects: -1}},
+		{site{Base: "https://example.com", RedirectTo: "/(/"}},
+		{site{Base: "https://example.com", Endpoints: []endpointConfig{{Path: "x", ExpectScheme: "digest", RedirectTo: "https://sso"}}}},
+		{site{Base: "https://example.com", Endpoints: []endpointConfig{{Path: "x", ExpectScheme: "redirect"}}}},
+	}
+	for _, sites := range invalid {
+		if err := populateURLConfig(sites); err == nil {
+			t.Errorf("Expected error for %+v", sites[0])
+		}
+	}
+	sites := []site{site{Base: "https://example.com", BasicAuth: []string{"app"}, RedirectTo: "https://sso.example.com"}}
+	if err := populateURLConfig(sites); err != nil {
+		t.Fatal(err)
+	}
+	if got := wantedSchemeMessage(sites[0].endpoints[0]); !strings.Contains(got, schemeRedirect) {
+		t.Errorf("Incorrect wanted scheme %q", got)
+	}
+}
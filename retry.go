@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryBackoff  = time.Second
+)
+
+var (
+	defaultRetryStatuses = []int{502, 503, 504}
+	defaultRetryErrors   = []string{errorConnect, errorTimeout}
+	errorCategories      = []string{errorDNS, errorConnect, errorTLS, errorTimeout, errorProtocol}
+)
+
+// retryPolicy is the [site.retry] table, deciding which failed requests are
+// worth another attempt. The backoff doubles after every attempt.
+type retryPolicy struct {
+	Attempts int      `toml:"attempts"`
+	Backoff  duration `toml:"backoff"`
+	Statuses []int    `toml:"statuses"`
+	Errors   []string `toml:"errors"`
+}
+
+// loadRetryPolicy validates the policy and fills in the defaults
+func loadRetryPolicy(policy *retryPolicy) error {
+	if policy.Attempts == 0 {
+		policy.Attempts = defaultRetryAttempts
+	}
+	if policy.Attempts < 1 {
+		return fmt.Errorf("retry attempts must be at least 1, got %d", policy.Attempts)
+	}
+	if policy.Backoff.Duration == 0 {
+		policy.Backoff.Duration = defaultRetryBackoff
+	}
+	if policy.Statuses == nil {
+		policy.Statuses = defaultRetryStatuses
+	}
+	if policy.Errors == nil {
+		policy.Errors = defaultRetryErrors
+	}
+	for _, category := range policy.Errors {
+		if !containsString(errorCategories, category) {
+			return fmt.Errorf("unknown retry error category %q, valid categories are: %s",
+				category, strings.Join(errorCategories, ", "))
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, i int) bool {
+	for _, item := range list {
+		if item == i {
+			return true
+		}
+	}
+	return false
+}
+
+// retryable tells whether the outcome of an attempt should be retried
+func (policy *retryPolicy) retryable(attempt int, statusCode int, err error) bool {
+	if policy == nil || attempt >= policy.Attempts {
+		return false
+	}
+	if err != nil {
+		return containsString(policy.Errors, classifyError(err))
+	}
+	return containsInt(policy.Statuses, statusCode)
+}
+
+// backoff waits before the next attempt, or until ctx is done
+func (policy *retryPolicy) backoff(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(policy.Backoff.Duration << uint(attempt-1))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// requestWithRetries sends the endpoint's request, retrying according to the
+// endpoint's retry policy, and records the number of attempts made
+func requestWithRetries(ctx context.Context, ep *endpoint) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		ep.Attempts = attempt
+		req, err := newRequest(ctx, ep)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		response, err := doRequest(ctx, ep, req)
+		ep.Duration = time.Since(start)
+		statusCode := 0
+		if err == nil {
+			statusCode = response.StatusCode
+		}
+		if !ep.retry.retryable(attempt, statusCode, err) {
+			return response, err
+		}
+		if err == nil {
+			response.Body.Close()
+		}
+		if err := ep.retry.backoff(ctx, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadRetryPolicy(t *testing.T) {
+	policy := retryPolicy{}
+	if err := loadRetryPolicy(&policy); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Attempts != defaultRetryAttempts || policy.Backoff.Duration != defaultRetryBackoff ||
+		len(policy.Statuses) != 3 || len(policy.Errors) != 2 {
+		t.Errorf("Defaults not applied %+v", policy)
+	}
+	if err := loadRetryPolicy(&retryPolicy{Errors: []string{"flaky"}}); err == nil {
+		t.Error("Expected error for unknown error category")
+	}
+	if err := loadRetryPolicy(&retryPolicy{Attempts: -1}); err == nil {
+		t.Error("Expected error for negative attempts")
+	}
+}
+
+func TestCheckURLRetries(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+	policy := &retryPolicy{Attempts: 3, Backoff: duration{time.Millisecond}}
+	if err := loadRetryPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+
+	ep := endpoint{URL: ts.URL, BaShouldBe: true, retry: policy}
+	checkURL(context.Background(), &ep)
+	if !ep.Success || ep.Attempts != 3 {
+		t.Errorf("Expected success after 3 attempts, got success=%t attempts=%d", ep.Success, ep.Attempts)
+	}
+	if got := successMessage(ep); got != "true (3 attempts)" {
+		t.Errorf("Incorrect success message %q", got)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	ep = endpoint{URL: ts.URL, BaShouldBe: true, retry: &retryPolicy{Attempts: 3, Backoff: duration{time.Millisecond}, Statuses: []int{503}}}
+	checkURL(context.Background(), &ep)
+	if ep.Success || ep.Attempts != 1 || ep.HTTPStatusCode != http.StatusBadGateway {
+		t.Errorf("Expected no retry for 502, got success=%t attempts=%d status=%d", ep.Success, ep.Attempts, ep.HTTPStatusCode)
+	}
+}
+
+func TestCheckURLRetryErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	URL := ts.URL
+	ts.Close()
+	policy := &retryPolicy{Attempts: 2, Backoff: duration{time.Millisecond}}
+	if err := loadRetryPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	ep := endpoint{URL: URL, retry: policy}
+	checkURL(context.Background(), &ep)
+	if !ep.Error || ep.ErrorCategory != errorConnect || ep.Attempts != 2 {
+		t.Errorf("Expected connect error after 2 attempts, got error=%t category=%q attempts=%d",
+			ep.Error, ep.ErrorCategory, ep.Attempts)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// schemeMTLS is the expected "scheme" of endpoints protected by requiring a
+// client certificate
+const schemeMTLS = "mtls"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsOptions is the [site.tls] table
+type tlsOptions struct {
+	CAFile             string `toml:"ca_file"`
+	CertFile           string `toml:"cert_file"`
+	KeyFile            string `toml:"key_file"`
+	ServerName         string `toml:"server_name"`
+	MinVersion         string `toml:"min_version"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+}
+
+// loadTLSConfig builds the TLS config of a site from its [site.tls] table
+func loadTLSConfig(options *tlsOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         options.ServerName,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+	if options.MinVersion != "" {
+		version, ok := tlsVersions[options.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid min_version %q, valid versions are: %s",
+				options.MinVersion, strings.Join(tlsVersionNames(), ", "))
+		}
+		config.MinVersion = version
+	}
+	if options.CAFile != "" {
+		pem, err := ioutil.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read ca_file: %s", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca_file %s", options.CAFile)
+		}
+	}
+	if (options.CertFile == "") != (options.KeyFile == "") {
+		return nil, fmt.Errorf("cert_file and key_file must be given together")
+	}
+	if options.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// loadSiteTLS loads the TLS config of the site, if it has a [site.tls] table
+func loadSiteTLS(site *site) (err error) {
+	if site.TLS == nil {
+		return nil
+	}
+	if site.tlsConfig, err = loadTLSConfig(site.TLS); err != nil {
+		return err
+	}
+	if site.TLS.InsecureSkipVerify {
+		fmt.Fprintf(os.Stderr, "WARNING: TLS certificate verification is disabled for %s, "+
+			"anyone in between can read the credentials\n", site.Base)
+	}
+	return nil
+}
+
+func tlsVersionNames() []string {
+	names := make([]string, 0, len(tlsVersions))
+	for name := range tlsVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func tlsVersionName(version uint16) string {
+	for name, v := range tlsVersions {
+		if v == version {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// tlsMessage describes the TLS version and how long the certificate is valid
+func tlsMessage(ep endpoint) string {
+	if ep.TLSVersion == "" {
+		return ""
+	}
+	days := int(time.Until(ep.CertExpiry).Hours() / 24)
+	if days < 0 {
+		return fmt.Sprintf("%s, cert expired %d days ago", ep.TLSVersion, -days)
+	}
+	return fmt.Sprintf("%s, cert expires in %d days", ep.TLSVersion, days)
+}
+
+// recordTLS records the TLS version and the certificate expiry of the response
+func recordTLS(ep *endpoint, response *http.Response) {
+	if response.TLS == nil {
+		return
+	}
+	ep.TLSVersion = tlsVersionName(response.TLS.Version)
+	if len(response.TLS.PeerCertificates) > 0 {
+		ep.CertExpiry = response.TLS.PeerCertificates[0].NotAfter
+	}
+}
+
+// newClientWithoutCert builds a client for the site that never presents the
+// client certificate, to check that the server requires one
+func newClientWithoutCert(site *site) *http.Client {
+	withoutCert := *site
+	if site.tlsConfig != nil {
+		withoutCert.tlsConfig = site.tlsConfig.Clone()
+		withoutCert.tlsConfig.Certificates = nil
+	}
+	return newSiteClient(&withoutCert)
+}
+
+// clientCertAlerts are the alerts of servers requiring a client certificate
+// that get none, certificate_required in TLS 1.3 and bad_certificate before
+var clientCertAlerts = []string{"remote error: tls: certificate required", "remote error: tls: bad certificate"}
+
+// clientCertRejected tells whether the server aborted the handshake because
+// it got no client certificate. Other alerts, like a protocol version that is
+// not supported, are TLS errors.
+func clientCertRejected(err error) bool {
+	for _, alert := range clientCertAlerts {
+		if strings.Contains(err.Error(), alert) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMTLS checks an endpoint that is expected to require a client
+// certificate. The request is made without one, so it must be rejected
+// during the handshake.
+func checkMTLS(ctx context.Context, ep *endpoint) {
+	response, err := requestWithRetries(ctx, ep)
+	if err != nil {
+		if !clientCertRejected(err) {
+			recordError(ep, err)
+			return
+		}
+		ep.BaEnabled = true
+		ep.Success = true
+		ep.AuthScheme = "mTLS"
+		ep.HTTPStatus = "handshake rejected without client certificate"
+		return
+	}
+	defer response.Body.Close()
+	ep.HTTPStatusCode = response.StatusCode
+	ep.HTTPStatus = response.Status
+	ep.Redirects = redirectChain(response)
+	recordTLS(ep, response)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeClientCert writes a self-signed client certificate and its key to dir
+func writeClientCert(t *testing.T, dir string) (certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ba_checker"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file string, blockType string, der []byte) {
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSiteTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ba_checker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="internal"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", ts.Certificate().Raw)
+	certFile, keyFile := writeClientCert(t, dir)
+
+	testCases := []struct {
+		options tlsOptions
+		success bool
+	}{
+		{tlsOptions{}, false},
+		{tlsOptions{CAFile: caFile}, false},
+		{tlsOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, true},
+		{tlsOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "example.com", MinVersion: "1.2"}, true},
+		{tlsOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "wrong.example.org"}, false},
+		{tlsOptions{CertFile: certFile, KeyFile: keyFile, InsecureSkipVerify: true}, true},
+	}
+	for _, tc := range testCases {
+		options := tc.options
+		sites := []site{site{Base: ts.URL, BasicAuth: []string{"admin"}, TLS: &options}}
+		if err := populateURLConfig(sites); err != nil {
+			t.Fatal(err)
+		}
+		checkSites(context.Background(), sites, 1)
+		ep := sites[0].endpoints[0]
+		if ep.Success != tc.success {
+			t.Errorf("Incorrect success %t with %+v: %s", ep.Success, tc.options, ep.ErrorMessage)
+		}
+		if !ep.Success {
+			continue
+		}
+		if ep.TLSVersion != "1.3" {
+			t.Errorf("Incorrect TLS version %q", ep.TLSVersion)
+		}
+		if !ep.CertExpiry.Equal(ts.Certificate().NotAfter) {
+			t.Errorf("Incorrect certificate expiry %s", ep.CertExpiry)
+		}
+	}
+
+	invalid := []tlsOptions{
+		{MinVersion: "1.4"},
+		{CAFile: filepath.Join(dir, "missing.pem")},
+		{CAFile: keyFile},
+		{CertFile: certFile},
+		{CertFile: certFile, KeyFile: caFile},
+	}
+	for _, options := range invalid {
+		options := options
+		if err := populateURLConfig([]site{site{Base: ts.URL, TLS: &options}}); err == nil {
+			t.Errorf("Expected error for %+v", options)
+		}
+	}
+}
+
+func TestTLSMessage(t *testing.T) {
+	ep := endpoint{TLSVersion: "1.2", CertExpiry: time.Now().Add(30*24*time.Hour + time.Hour)}
+	if got := tlsMessage(ep); got != "1.2, cert expires in 30 days" {
+		t.Errorf("Incorrect TLS message %q", got)
+	}
+	ep.CertExpiry = time.Now().Add(-50 * time.Hour)
+	if got := tlsMessage(ep); got != "1.2, cert expired 2 days ago" {
+		t.Errorf("Incorrect TLS message %q", got)
+	}
+	if got := tlsMessage(endpoint{}); got != "" {
+		t.Errorf("Incorrect TLS message %q without TLS", got)
+	}
+}
+
+func TestCheckMTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ba_checker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeClientCert(t, dir)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	required := httptest.NewUnstartedServer(handler)
+	required.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	required.StartTLS()
+	defer required.Close()
+	optional := httptest.NewTLSServer(handler)
+	defer optional.Close()
+
+	testCases := []struct {
+		ts      *httptest.Server
+		success bool
+	}{
+		{required, true},
+		{optional, false},
+	}
+	// A server only speaking TLS 1.2 rejects the handshake for another reason
+	legacy := httptest.NewUnstartedServer(handler)
+	legacy.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MaxVersion: tls.VersionTLS12}
+	legacy.StartTLS()
+	defer legacy.Close()
+	sites := []site{
+		site{
+			Base:      legacy.URL,
+			TLS:       &tlsOptions{InsecureSkipVerify: true, MinVersion: "1.3"},
+			Endpoints: []endpointConfig{{Path: "admin", ExpectScheme: "mtls"}},
+		},
+	}
+	if err := populateURLConfig(sites); err != nil {
+		t.Fatal(err)
+	}
+	checkSites(context.Background(), sites, 1)
+	if ep := sites[0].endpoints[0]; ep.Success || !ep.Error || ep.ErrorCategory != errorTLS {
+		t.Errorf("Incorrect result for a protocol version alert: success %t, error %q %q", ep.Success, ep.ErrorCategory, ep.ErrorMessage)
+	}
+
+	for _, tc := range testCases {
+		// The configured client certificate must not be sent
+		sites := []site{
+			site{
+				Base:      tc.ts.URL,
+				TLS:       &tlsOptions{CertFile: certFile, KeyFile: keyFile, InsecureSkipVerify: true},
+				Endpoints: []endpointConfig{{Path: "admin", ExpectScheme: "mTLS"}},
+			},
+		}
+		if err := populateURLConfig(sites); err != nil {
+			t.Fatal(err)
+		}
+		checkSites(context.Background(), sites, 1)
+		ep := sites[0].endpoints[0]
+		if ep.Success != tc.success || ep.Error {
+			t.Errorf("Incorrect result for %s: success %t, error %q", tc.ts.URL, ep.Success, ep.ErrorMessage)
+		}
+		if got := wantedSchemeMessage(ep); got != schemeMTLS {
+			t.Errorf("Incorrect wanted scheme %q", got)
+		}
+	}
+}